@@ -1,6 +1,7 @@
 package grafanadata
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,10 +11,15 @@ import (
 
 // returns all the dashboards for a grafana instance
 func (c *Client) FetchDashboards() ([]DashboardSearch, error) {
+	return c.FetchDashboardsContext(context.Background())
+}
+
+// returns all the dashboards for a grafana instance using the provided context
+func (c *Client) FetchDashboardsContext(ctx context.Context) ([]DashboardSearch, error) {
 	host := strings.TrimSuffix(c.baseURL.String(), "/")
 
 	q := fmt.Sprintf("%v/api/search?type=dash-db", host)
-	req, err := c.NewRequest(http.MethodGet, q, nil)
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, q, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -22,6 +28,7 @@ func (c *Client) FetchDashboards() ([]DashboardSearch, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -29,7 +36,7 @@ func (c *Client) FetchDashboards() ([]DashboardSearch, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("grafana response %v. %v", resp.StatusCode, string(body))
+		return nil, newAPIError(req, resp.StatusCode, body)
 	}
 
 	var search []DashboardSearch
@@ -37,15 +44,17 @@ func (c *Client) FetchDashboards() ([]DashboardSearch, error) {
 	return search, err
 }
 
-// returns all the panels for a dashboard
+// returns all the panels for a dashboard, including panels inside rows and nested panels
 func (c *Client) FetchPanelsFromDashboard(dashboard DashboardResponse) []PanelSearch {
 	var search []PanelSearch
-	for i := range dashboard.Dashboard.Panels {
-		p := dashboard.Dashboard.Panels[i]
+	dashboard.WalkPanels(func(p *Panel, path []string) bool {
 		search = append(search, PanelSearch{
 			ID:    p.ID,
 			Title: p.Title,
+			Type:  p.Type,
+			Path:  path,
 		})
-	}
+		return true
+	})
 	return search
 }
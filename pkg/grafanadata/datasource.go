@@ -0,0 +1,459 @@
+package grafanadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Pseudo datasources that do not query a configured datasource themselves.
+const (
+	// MixedDatasourceUID marks panels whose targets each have their own datasource.
+	MixedDatasourceUID = "-- Mixed --"
+	// DashboardDatasourceUID marks panels that reuse the results of another panel.
+	DashboardDatasourceUID = "-- Dashboard --"
+	// GrafanaDatasourceUID is Grafana's built-in datasource, e.g. for random walks.
+	GrafanaDatasourceUID = "grafana"
+
+	pseudoDatasourceType    = "datasource"
+	legacyGrafanaDatasource = "-- Grafana --"
+)
+
+// UnmarshalJSON decodes a datasource reference. Besides the {"type", "uid"} object of
+// current dashboards it accepts the plain name strings of older dashboards, template
+// variables such as "${DS_PROMETHEUS}" and the names of the pseudo datasources.
+func (d *Datasource) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*d = legacyDatasource(name)
+		return nil
+	}
+
+	type plain Datasource
+	var ds plain
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return err
+	}
+	*d = Datasource(ds)
+
+	return nil
+}
+
+// legacyDatasource converts the string datasource reference of older dashboards.
+func legacyDatasource(name string) Datasource {
+	switch {
+	case name == "":
+		return Datasource{}
+	case name == MixedDatasourceUID, name == DashboardDatasourceUID:
+		return Datasource{Type: pseudoDatasourceType, UID: name}
+	case name == legacyGrafanaDatasource, name == GrafanaDatasourceUID:
+		return Datasource{Type: pseudoDatasourceType, UID: GrafanaDatasourceUID}
+	case strings.Contains(name, "$"):
+		return Datasource{UID: name}
+	}
+	return Datasource{Name: name}
+}
+
+// IsMixed reports whether the datasource is the "-- Mixed --" pseudo datasource.
+func (d Datasource) IsMixed() bool {
+	return d.UID == MixedDatasourceUID || d.Name == MixedDatasourceUID
+}
+
+// IsDashboard reports whether the datasource is the "-- Dashboard --" pseudo datasource.
+func (d Datasource) IsDashboard() bool {
+	return d.UID == DashboardDatasourceUID || d.Name == DashboardDatasourceUID
+}
+
+// isEmpty reports whether the reference selects no datasource, i.e. the default one.
+func (d Datasource) isEmpty() bool {
+	return d.UID == "" && d.Name == ""
+}
+
+// datasourceRef decodes the datasource of a query target, which is either a
+// Datasource, its JSON object or a legacy string.
+func datasourceRef(v any) Datasource {
+	switch ds := v.(type) {
+	case Datasource:
+		return ds
+	case string:
+		return legacyDatasource(ds)
+	case map[string]any:
+		var ref Datasource
+		ref.Type, _ = ds["type"].(string)
+		ref.UID, _ = ds["uid"].(string)
+		ref.Name, _ = ds["name"].(string)
+		return ref
+	}
+	return Datasource{}
+}
+
+// resolveDatasource turns a datasource reference into a concrete datasource: template
+// variables are interpolated, names are looked up to get the uid and an empty or
+// unresolvable reference selects the default datasource. Pseudo datasources are
+// returned unchanged.
+func (c *Client) resolveDatasource(ctx context.Context, ref Datasource, ip *Interpolator) (Datasource, error) {
+	if ref.isEmpty() {
+		return c.getDefaultDatasource(ctx)
+	}
+	if ref.IsExpression() {
+		return expressionDatasource(), nil
+	}
+
+	uid := ip.Interpolate(ref.UID, "")
+	name := ip.Interpolate(ref.Name, "")
+	if strings.Contains(uid, "$") || strings.Contains(name, "$") {
+		c.log.Warn("datasource variable could not be resolved, using default datasource", "datasource", ref)
+		return c.getDefaultDatasource(ctx)
+	}
+
+	if pseudo := legacyDatasource(uid); pseudo.Type == pseudoDatasourceType {
+		return pseudo, nil
+	}
+	if pseudo := legacyDatasource(name); uid == "" && pseudo.Type == pseudoDatasourceType {
+		return pseudo, nil
+	}
+
+	switch {
+	case uid != "" && uid == ref.UID:
+		// a concrete uid, nothing to look up
+		return ref, nil
+	case uid != "":
+		// datasource variables hold the uid or, in older dashboards, the name
+		ds, err := c.GetDatasourceByUIDContext(ctx, uid)
+		if errors.Is(err, ErrNotFound) {
+			ds, err = c.GetDatasourceByNameContext(ctx, uid)
+		}
+		return ds, err
+	}
+
+	return c.GetDatasourceByNameContext(ctx, name)
+}
+
+// DefaultDatasourceCacheTTL is how long datasources are cached unless configured with
+// WithDatasourceCacheTTL.
+const DefaultDatasourceCacheTTL = 5 * time.Minute
+
+// WithDatasourceCacheTTL sets how long the client caches datasources. A negative TTL
+// disables caching.
+func WithDatasourceCacheTTL(ttl time.Duration) ClientOption {
+	return func(client *Client) {
+		client.datasourceTTL = ttl
+	}
+}
+
+// DatasourceHealth is the result of a datasource health check.
+type DatasourceHealth struct {
+	Status  string         `json:"status"` // "OK" or "ERROR"
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// OK reports whether the health check passed.
+func (h DatasourceHealth) OK() bool {
+	return h.Status == "OK"
+}
+
+// datasourceCache holds the datasources of the catalog, guarded by Client.mu.
+type datasourceCache struct {
+	byUID  map[string]cachedDatasource
+	list   []Datasource
+	listed time.Time
+}
+
+type cachedDatasource struct {
+	ds      Datasource
+	fetched time.Time
+}
+
+func (c *Client) cacheTTL() time.Duration {
+	if c.datasourceTTL == 0 {
+		return DefaultDatasourceCacheTTL
+	}
+	return c.datasourceTTL
+}
+
+// fresh reports whether something fetched at t may still be used when cached for ttl.
+func fresh(t time.Time, ttl time.Duration) bool {
+	return ttl > 0 && time.Since(t) < ttl
+}
+
+// cacheDatasources caches copies of the datasources, so that callers changing the
+// datasources they were given do not change the cache.
+func (c *Client) cacheDatasources(datasources ...Datasource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.datasources.byUID == nil {
+		c.datasources.byUID = map[string]cachedDatasource{}
+	}
+	now := time.Now()
+	for _, ds := range datasources {
+		c.datasources.byUID[ds.UID] = cachedDatasource{ds: ds.clone(), fetched: now}
+	}
+}
+
+// ref returns the reference queries send for the datasource, its type and uid like in
+// Grafana, so that the configuration of the datasource is not sent along. Datasources
+// that could not be resolved to a uid keep their name.
+func (d Datasource) ref() Datasource {
+	ref := Datasource{Type: d.Type, UID: d.UID}
+	if ref.UID == "" {
+		ref.Name = d.Name
+	}
+	return ref
+}
+
+// clone returns a copy of the datasource that shares no JSONData with d.
+func (d Datasource) clone() Datasource {
+	if d.JSONData != nil {
+		d.JSONData = cloneJSON(d.JSONData).(map[string]any)
+	}
+	return d
+}
+
+func cloneDatasources(datasources []Datasource) []Datasource {
+	clones := make([]Datasource, len(datasources))
+	for i, ds := range datasources {
+		clones[i] = ds.clone()
+	}
+	return clones
+}
+
+// cloneJSON copies the objects and arrays of a decoded JSON value.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		clone := make(map[string]any, len(v))
+		for k, e := range v {
+			clone[k] = cloneJSON(e)
+		}
+		return clone
+	case []any:
+		clone := make([]any, len(v))
+		for i, e := range v {
+			clone[i] = cloneJSON(e)
+		}
+		return clone
+	}
+	return v
+}
+
+// ListDatasources returns every datasource configured in grafana.
+func (c *Client) ListDatasources() ([]Datasource, error) {
+	return c.ListDatasourcesContext(context.Background())
+}
+
+// ListDatasourcesContext returns every datasource configured in grafana using the provided context.
+func (c *Client) ListDatasourcesContext(ctx context.Context) ([]Datasource, error) {
+	c.mu.Lock()
+	if c.datasources.list != nil && fresh(c.datasources.listed, c.cacheTTL()) {
+		list := cloneDatasources(c.datasources.list)
+		c.mu.Unlock()
+		return list, nil
+	}
+	c.mu.Unlock()
+
+	c.log.Debug("getting datasources", "host", c.GetHost())
+
+	var datasources []Datasource
+	if err := c.getJSON(ctx, "/api/datasources", nil, &datasources); err != nil {
+		return nil, fmt.Errorf("failed to get datasources with error %w", err)
+	}
+	if datasources == nil {
+		datasources = []Datasource{}
+	}
+
+	c.cacheDatasources(datasources...)
+
+	c.mu.Lock()
+	c.datasources.list = cloneDatasources(datasources)
+	c.datasources.listed = time.Now()
+	c.mu.Unlock()
+
+	return datasources, nil
+}
+
+// GetDatasourceByUID returns the datasource with the given uid.
+func (c *Client) GetDatasourceByUID(uid string) (Datasource, error) {
+	return c.GetDatasourceByUIDContext(context.Background(), uid)
+}
+
+// GetDatasourceByUIDContext returns the datasource with the given uid using the provided context.
+func (c *Client) GetDatasourceByUIDContext(ctx context.Context, uid string) (Datasource, error) {
+	c.mu.Lock()
+	cached, ok := c.datasources.byUID[uid]
+	c.mu.Unlock()
+	if ok && fresh(cached.fetched, c.cacheTTL()) {
+		return cached.ds.clone(), nil
+	}
+
+	var ds Datasource
+	if err := c.getJSON(ctx, "/api/datasources/uid/"+url.PathEscape(uid), nil, &ds); err != nil {
+		return ds, fmt.Errorf("failed to get datasource %v with error %w", uid, err)
+	}
+
+	c.cacheDatasources(ds)
+
+	return ds, nil
+}
+
+// GetDatasourceByName returns the datasource with the given name.
+func (c *Client) GetDatasourceByName(name string) (Datasource, error) {
+	return c.GetDatasourceByNameContext(context.Background(), name)
+}
+
+// GetDatasourceByNameContext returns the datasource with the given name using the provided context.
+func (c *Client) GetDatasourceByNameContext(ctx context.Context, name string) (Datasource, error) {
+	c.mu.Lock()
+	for _, cached := range c.datasources.byUID {
+		if cached.ds.Name == name && fresh(cached.fetched, c.cacheTTL()) {
+			c.mu.Unlock()
+			return cached.ds.clone(), nil
+		}
+	}
+	c.mu.Unlock()
+
+	var ds Datasource
+	if err := c.getJSON(ctx, "/api/datasources/name/"+url.PathEscape(name), nil, &ds); err != nil {
+		return ds, fmt.Errorf("failed to get datasource %v with error %w", name, err)
+	}
+
+	c.cacheDatasources(ds)
+
+	return ds, nil
+}
+
+// getDefaultDatasource returns the datasource marked as default, or an empty
+// Datasource when there is none.
+func (c *Client) getDefaultDatasource(ctx context.Context) (Datasource, error) {
+	datasources, err := c.ListDatasourcesContext(ctx)
+	if err != nil {
+		return Datasource{}, err
+	}
+
+	for _, ds := range datasources {
+		if ds.IsDefault {
+			return ds, nil
+		}
+	}
+
+	return Datasource{}, nil
+}
+
+// CheckDatasourceHealth runs the health check of the datasource with the given uid.
+func (c *Client) CheckDatasourceHealth(uid string) (DatasourceHealth, error) {
+	return c.CheckDatasourceHealthContext(context.Background(), uid)
+}
+
+// CheckDatasourceHealthContext runs the health check of the datasource with the given uid
+// using the provided context. Health checks are never cached. A failing check is
+// reported in the returned status, errors are only returned when the check could not run.
+func (c *Client) CheckDatasourceHealthContext(ctx context.Context, uid string) (DatasourceHealth, error) {
+	var health DatasourceHealth
+
+	err := c.getJSON(ctx, "/api/datasources/uid/"+url.PathEscape(uid)+"/health", nil, &health)
+
+	// grafana answers failed checks with 400 and the health result as body
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		if json.Unmarshal(apiErr.Body, &health) == nil && health.Status != "" {
+			return health, nil
+		}
+	}
+	if err != nil {
+		return health, fmt.Errorf("failed to check health of datasource %v with error %w", uid, err)
+	}
+
+	return health, nil
+}
+
+// DashboardDatasources resolves every datasource referenced by the panels, query
+// targets and query variables of a dashboard, e.g. to check that they exist and are
+// healthy. Pseudo datasources and expressions are skipped. References that cannot be resolved are
+// reported together in the returned error.
+func (c *Client) DashboardDatasources(dashboard DashboardResponse, opts ...PanelOption) ([]Datasource, error) {
+	return c.DashboardDatasourcesContext(context.Background(), dashboard, opts...)
+}
+
+// DashboardDatasourcesContext resolves every datasource referenced by a dashboard using the provided context.
+func (c *Client) DashboardDatasourcesContext(ctx context.Context, dashboard DashboardResponse, opts ...PanelOption) ([]Datasource, error) {
+	options := newPanelOptions(opts...)
+	ip := options.interpolator(dashboard.Dashboard.Templating.List)
+
+	var refs []Datasource
+	for _, tpl := range dashboard.Dashboard.Templating.List {
+		if tpl.Type == "query" {
+			refs = append(refs, tpl.Datasource)
+		}
+	}
+	dashboard.WalkPanels(func(panel *Panel, _ []string) bool {
+		if panel.Type == "row" {
+			return true
+		}
+		refs = append(refs, panel.Datasource)
+		for _, t := range panel.Targets {
+			if target, ok := t.(map[string]any); ok && target["datasource"] != nil {
+				refs = append(refs, datasourceRef(target["datasource"]))
+			}
+		}
+		return true
+	})
+
+	seen := map[string]bool{}
+	seenRefs := map[[2]string]bool{}
+	var datasources []Datasource
+	var errs []error
+	for _, ref := range refs {
+		key := [2]string{ref.UID, ref.Name}
+		if seenRefs[key] {
+			continue
+		}
+		seenRefs[key] = true
+
+		ds, err := c.resolveDatasource(ctx, ref, ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ds.UID == "" || ds.Type == pseudoDatasourceType || ds.IsExpression() || seen[ds.UID] {
+			continue
+		}
+		seen[ds.UID] = true
+		datasources = append(datasources, ds)
+	}
+
+	return datasources, errors.Join(errs...)
+}
+
+// queryDashboardDatasource answers a panel using the "-- Dashboard --" datasource with
+// the results of the panel its query references, like Grafana does.
+func (c *Client) queryDashboardDatasource(ctx context.Context, panel *Panel, dashboard DashboardResponse, opts ...PanelOption) (Results, error) {
+	var sourceID int
+	for _, t := range panel.Targets {
+		target, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		if id, ok := target["panelId"].(float64); ok {
+			sourceID = int(id)
+			break
+		}
+	}
+
+	source := dashboard.GetPanelByID(sourceID)
+	if source == nil {
+		return Results{}, fmt.Errorf("%w: %v referenced by the dashboard datasource of panel %v", ErrPanelNotFound, sourceID, panel.ID)
+	}
+	if source.ID == panel.ID || source.Datasource.IsDashboard() {
+		return Results{}, fmt.Errorf("panel %v uses the dashboard datasource of panel %v which is not a query panel", panel.ID, sourceID)
+	}
+
+	c.log.Debug("using results of another panel", "panelID", panel.ID, "sourcePanelID", sourceID)
+
+	return c.queryPanel(ctx, source, dashboard, opts...)
+}
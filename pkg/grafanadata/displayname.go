@@ -0,0 +1,274 @@
+package grafanadata
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// autoLegend is the legend format that lets Grafana name Prometheus series itself.
+const autoLegend = "__auto"
+
+// valueFieldName is the field name Grafana gives the values of time series.
+const valueFieldName = "Value"
+
+// legendRegex matches the {{label}} references of a legend format, whitespace included.
+var legendRegex = regexp.MustCompile(`\{\{\s*(.+?)\s*\}\}`)
+
+// fieldVariableRegex matches the ${__field.*} and ${__series.*} references of display names.
+var fieldVariableRegex = regexp.MustCompile(`\$\{(__field|__series)\.([^}]+)\}`)
+
+// frames returns the frames of every query ordered by refId.
+func (r Results) frames() []Frame {
+	refs := make([]string, 0, len(r.Results))
+	for ref := range r.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	var frames []Frame
+	for _, ref := range refs {
+		frames = append(frames, r.Results[ref].Frames...)
+	}
+	return frames
+}
+
+// FieldDisplayName returns the name Grafana shows in the legend for field i of a frame
+// of the given refId. The display name of the panel field config and its overrides is
+// used first, then the name set by the datasource or the legend format of the target,
+// and otherwise the name is built from the frame name, field name and labels.
+func (r Results) FieldDisplayName(ref string, frame Frame, i int) string {
+	return newDisplayNamer(r).name(ref, frame, i)
+}
+
+// displayNamer names fields like Grafana's getFieldDisplayName, which looks at every
+// frame of the panel to decide what tells the series apart.
+type displayNamer struct {
+	results     Results
+	singleLabel string // the label name of every series when they all have only that one
+	namesDiffer bool   // whether the frames have different names
+}
+
+func newDisplayNamer(results Results) *displayNamer {
+	return newFrameNamer(results, results.frames())
+}
+
+// newFrameNamer names the fields of the given frames using the legends, targets and
+// field config of the results.
+func newFrameNamer(results Results, frames []Frame) *displayNamer {
+	n := &displayNamer{results: results}
+
+	for i := 1; i < len(frames); i++ {
+		if frames[i].Schema.Name != frames[i-1].Schema.Name {
+			n.namesDiffer = true
+			break
+		}
+	}
+
+	single, ok := "", true
+	for _, frame := range frames {
+		for _, field := range frame.Schema.Fields {
+			for key := range field.Labels {
+				if single == "" {
+					single = key
+				} else if key != single {
+					ok = false
+				}
+			}
+		}
+	}
+	if ok {
+		n.singleLabel = single
+	}
+
+	return n
+}
+
+// name returns the display name of field i of a frame.
+func (n *displayNamer) name(ref string, frame Frame, i int) string {
+	config := n.fieldConfig(ref, frame, i)
+	if config.DisplayName != "" {
+		return n.interpolate(config.DisplayName, frame, i)
+	}
+	return n.sourceName(ref, frame, i, config)
+}
+
+// sourceName is the name of a field before the display name of the panel applies, the
+// name matchByName and matchByRegexp overrides compare with.
+func (n *displayNamer) sourceName(ref string, frame Frame, i int, config FieldConfig) string {
+	field := frameField(frame, i)
+	if config.DisplayNameFromDS != "" {
+		return config.DisplayNameFromDS
+	}
+	if name, ok := n.legendName(ref, field); ok {
+		return name
+	}
+	if n.results.Targets[ref].IsExpression() {
+		return expressionDisplayName(ref, field.Labels)
+	}
+	return n.defaultName(frame, i)
+}
+
+// legendName returns the name the legend format of the target of ref gives a field.
+func (n *displayNamer) legendName(ref string, field Field) (string, bool) {
+	switch legend := n.results.Legends[ref]; legend {
+	case "":
+	case autoLegend:
+		// Prometheus names series without labels by their query
+		if expr := n.results.Targets[ref].Expr; len(field.Labels) == 0 && expr != "" {
+			return expr, true
+		}
+	default:
+		return applyLegend(legend, field.Labels), true
+	}
+	return "", false
+}
+
+// defaultName builds a name from the frame name, the field name and the labels.
+func (n *displayNamer) defaultName(frame Frame, i int) string {
+	field := frameField(frame, i)
+	frameName := frame.Schema.Name
+
+	var parts []string
+	frameNameAdded, labelsAdded := false, false
+	if n.namesDiffer && frameName != "" {
+		parts = append(parts, frameName)
+		frameNameAdded = true
+	}
+	if field.Name != "" && field.Name != valueFieldName {
+		parts = append(parts, field.Name)
+	}
+	if len(field.Labels) > 0 {
+		if n.singleLabel == "" {
+			parts = append(parts, formatLabels(field.Labels))
+			labelsAdded = true
+		} else if value := field.Labels[n.singleLabel]; value != "" {
+			parts = append(parts, value)
+			labelsAdded = true
+		}
+	}
+	if !frameNameAdded && !labelsAdded && field.Name == valueFieldName && frameName != "" {
+		parts = append(parts, frameName)
+	}
+
+	name := valueFieldName
+	switch {
+	case len(parts) > 0:
+		name = strings.Join(parts, " ")
+	case field.Name != "":
+		name = field.Name
+	}
+	if name == field.Name {
+		name = uniqueFieldName(frame, i)
+	}
+	return name
+}
+
+// uniqueFieldName numbers fields that share their name with other fields of the frame.
+func uniqueFieldName(frame Frame, i int) string {
+	name := frameField(frame, i).Name
+	count, index := 0, 0
+	for j, field := range frame.Schema.Fields {
+		if field.Name == name {
+			count++
+			if j == i {
+				index = count
+			}
+		}
+	}
+	if count > 1 {
+		return fmt.Sprintf("%v %v", name, index)
+	}
+	return name
+}
+
+// fieldConfig returns the config of field i: the config of the frame field, the panel
+// defaults for anything the datasource did not set, and then the matching overrides.
+func (n *displayNamer) fieldConfig(ref string, frame Frame, i int) FieldConfig {
+	config := frameFieldConfig(frameField(frame, i))
+	config.applyDefaults(n.results.FieldConfig.Defaults)
+
+	var sourceName string
+	for _, override := range n.results.FieldConfig.Overrides {
+		if override.Matcher.ID == "byName" || override.Matcher.ID == "byNames" || override.Matcher.ID == "byRegexp" {
+			if sourceName == "" {
+				sourceName = n.sourceName(ref, frame, i, frameFieldConfig(frameField(frame, i)))
+			}
+		}
+		if !override.Matcher.matches(ref, frame, i, sourceName) {
+			continue
+		}
+		for _, property := range override.Properties {
+			config.setProperty(property.ID, property.Value)
+		}
+	}
+
+	return config
+}
+
+// interpolate replaces the ${__field.name}, ${__field.labels}, ${__field.labels.x} and
+// ${__series.name} references of a display name.
+func (n *displayNamer) interpolate(s string, frame Frame, i int) string {
+	field := frameField(frame, i)
+	return fieldVariableRegex.ReplaceAllStringFunc(s, func(match string) string {
+		groups := fieldVariableRegex.FindStringSubmatch(match)
+		path := groups[2]
+		switch {
+		case groups[1] == "__series" && path == "name":
+			if frame.Schema.Name != "" {
+				return frame.Schema.Name
+			}
+			return n.defaultName(frame, i)
+		case path == "name":
+			return field.Name
+		case path == "labels":
+			return formatLabels(field.Labels)
+		case path == "labels.__values":
+			keys := sortedLabelKeys(field.Labels)
+			values := make([]string, len(keys))
+			for j, key := range keys {
+				values[j] = field.Labels[key]
+			}
+			return strings.Join(values, ", ")
+		case strings.HasPrefix(path, "labels."):
+			return field.Labels[strings.TrimPrefix(path, "labels.")]
+		}
+		return match
+	})
+}
+
+// frameField returns the schema of field i, or an empty field for frames without schema.
+func frameField(frame Frame, i int) Field {
+	if i >= 0 && i < len(frame.Schema.Fields) {
+		return frame.Schema.Fields[i]
+	}
+	return Field{}
+}
+
+// formatLabels formats labels like Grafana, e.g. {instance="a", job="b"}.
+func formatLabels(labels map[string]string) string {
+	keys := sortedLabelKeys(labels)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + `="` + labels[key] + `"`
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyLegend renders a legend format, replacing {{label}} references by the value
+// of the label, or nothing for labels the series does not have, like Prometheus.
+func applyLegend(legend string, labels map[string]string) string {
+	return legendRegex.ReplaceAllStringFunc(legend, func(match string) string {
+		return labels[legendRegex.FindStringSubmatch(match)[1]]
+	})
+}
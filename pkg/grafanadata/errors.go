@@ -0,0 +1,132 @@
+package grafanadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors returned by the client. Use errors.Is to test for them.
+var (
+	ErrDashboardNotFound         = errors.New("dashboard not found")
+	ErrPanelNotFound             = errors.New("panel not found")
+	ErrLibraryPanelNotFound      = errors.New("library panel not found")
+	ErrUnauthorized              = errors.New("unauthorized")
+	ErrForbidden                 = errors.New("forbidden")
+	ErrNotFound                  = errors.New("not found")
+	ErrBadRequest                = errors.New("bad request")
+	ErrVariableCycle             = errors.New("template variables reference each other in a cycle")
+	ErrUnknownReducer            = errors.New("unknown reducer")
+	ErrUnsupportedTransformation = errors.New("unsupported transformation")
+)
+
+// APIError is returned when Grafana responds with an unexpected status code.
+// The message and trace id are parsed from Grafana's JSON error body when present.
+type APIError struct {
+	StatusCode int
+	Endpoint   string
+	Message    string
+	TraceID    string
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = string(e.Body)
+	}
+
+	s := fmt.Sprintf("grafana returned status %v for %v", e.StatusCode, e.Endpoint)
+	if msg != "" {
+		s += ": " + msg
+	}
+	if e.TraceID != "" {
+		s += " (traceID " + e.TraceID + ")"
+	}
+
+	return s
+}
+
+// Is maps the status code onto the matching sentinel error so that
+// errors.Is(err, ErrUnauthorized) works for any failed request.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	}
+	return false
+}
+
+// newAPIError builds an APIError for a request that got a non-successful status and its already read body.
+func newAPIError(req *http.Request, status int, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: status,
+		Endpoint:   req.URL.Path,
+		Body:       body,
+	}
+
+	var payload struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+		TraceID string `json:"traceID"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		apiErr.Message = payload.Message
+		if apiErr.Message == "" {
+			apiErr.Message = payload.Error
+		}
+		apiErr.TraceID = payload.TraceID
+	}
+
+	return apiErr
+}
+
+// QueryError describes a single query (refId) that failed inside an otherwise
+// successful /api/ds/query response.
+type QueryError struct {
+	RefID   string
+	Status  int
+	Message string
+	Source  string
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("query %v failed with status %v: %v", e.RefID, e.Status, e.Message)
+}
+
+// PartialResultError is returned when one or more queries of a panel failed.
+// The results of the successful queries are still available on Results.
+type PartialResultError struct {
+	Errors []QueryError
+}
+
+func (e *PartialResultError) Error() string {
+	refs := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		refs[i] = e.Errors[i].RefID
+	}
+
+	s := fmt.Sprintf("%v of the panel queries failed (%v)", len(e.Errors), strings.Join(refs, ", "))
+	if len(e.Errors) > 0 {
+		s += ": " + e.Errors[0].Error()
+	}
+
+	return s
+}
+
+// Unwrap exposes every query error to errors.Is and errors.As.
+func (e *PartialResultError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i := range e.Errors {
+		errs[i] = e.Errors[i]
+	}
+	return errs
+}
@@ -0,0 +1,144 @@
+package grafanadata
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ExpressionDatasourceUID is the uid and type of Grafana's server-side expressions.
+const ExpressionDatasourceUID = "__expr__"
+
+// legacyExpressionDatasourceUID is the uid older dashboards use for expressions.
+const legacyExpressionDatasourceUID = "-100"
+
+// expressionTypes are the kinds of server-side expressions.
+var expressionTypes = map[string]bool{
+	"math":               true,
+	"reduce":             true,
+	"resample":           true,
+	"threshold":          true,
+	"classic_conditions": true,
+	"sql":                true,
+}
+
+// expressionRefRegex matches the $A and ${A} references of math expressions.
+var expressionRefRegex = regexp.MustCompile(`\$(?:\{([^}]+)\}|([A-Za-z0-9_]+))`)
+
+// expressionDatasource returns the datasource reference Grafana expects on expression queries.
+func expressionDatasource() Datasource {
+	return Datasource{Type: ExpressionDatasourceUID, UID: ExpressionDatasourceUID, Name: "Expression"}
+}
+
+// IsExpression reports whether the datasource is Grafana's server-side expressions.
+func (d Datasource) IsExpression() bool {
+	return d.UID == ExpressionDatasourceUID || d.Type == ExpressionDatasourceUID || d.UID == legacyExpressionDatasourceUID
+}
+
+// isExpressionTarget reports whether a query target is a server-side expression. Targets
+// without a datasource are recognized by their expression type.
+func isExpressionTarget(t map[string]any) bool {
+	if raw, ok := t["datasource"]; ok && raw != nil {
+		return datasourceRef(raw).IsExpression()
+	}
+	kind, _ := t["type"].(string)
+	_, hasExpression := t["expression"]
+	_, hasConditions := t["conditions"]
+	return expressionTypes[kind] && (hasExpression || hasConditions)
+}
+
+// expressionRefs returns the refIds an expression target reads.
+func expressionRefs(t map[string]any) []string {
+	expression, _ := t["expression"].(string)
+	kind, _ := t["type"].(string)
+
+	var refs []string
+	switch kind {
+	case "math":
+		for _, m := range expressionRefRegex.FindAllStringSubmatch(expression, -1) {
+			refs = append(refs, m[1]+m[2])
+		}
+	case "classic_conditions":
+		conditions, _ := t["conditions"].([]any)
+		for _, c := range conditions {
+			condition, _ := c.(map[string]any)
+			query, _ := condition["query"].(map[string]any)
+			params, _ := query["params"].([]any)
+			if len(params) > 0 {
+				if ref, ok := params[0].(string); ok {
+					refs = append(refs, ref)
+				}
+			}
+		}
+	case "sql":
+		// the refIds are used as table names and cannot be told apart from SQL
+		// reliably, see sqlExpressionInputs
+	default:
+		// reduce, resample and threshold read a single input, written as "A" or "$A"
+		ref := strings.Trim(strings.TrimPrefix(strings.TrimSpace(expression), "$"), "{}")
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs
+}
+
+// sqlExpressionInputs makes SQL expressions depend on every other target of the panel,
+// as the tables a query reads cannot be parsed from SQL reliably.
+func sqlExpressionInputs(targets map[string]TargetOptions) {
+	for ref, target := range targets {
+		if target.Expression != "sql" {
+			continue
+		}
+		target.DependsOn = nil
+		for other := range targets {
+			if other != ref {
+				target.DependsOn = append(target.DependsOn, other)
+			}
+		}
+		sort.Strings(target.DependsOn)
+		targets[ref] = target
+	}
+}
+
+// expressionInputs returns the refIds of the targets needed to evaluate the given
+// targets: the targets themselves and, transitively, the inputs of expressions.
+func expressionInputs(targets map[string]TargetOptions, refs []string) map[string]bool {
+	needed := map[string]bool{}
+	var visit func(ref string)
+	visit = func(ref string) {
+		if needed[ref] {
+			return
+		}
+		needed[ref] = true
+		for _, dep := range targets[ref].DependsOn {
+			visit(dep)
+		}
+	}
+	for _, ref := range refs {
+		visit(ref)
+	}
+	return needed
+}
+
+// expressionDisplayName names the series of an expression like Grafana: the refId
+// followed by the labels of the series.
+func expressionDisplayName(ref string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if !strings.HasPrefix(key, "__") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ref
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + `"` + labels[key] + `"`
+	}
+	return ref + " {" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,461 @@
+package grafanadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DisplayValue is a value rendered like Grafana displays it: formatted in the unit of
+// the field, or replaced by a value mapping, and colored by the mapping or threshold.
+type DisplayValue struct {
+	Text      string     // the formatted number or the mapped text
+	Prefix    string     // unit prefix, e.g. "$"
+	Suffix    string     // unit suffix, e.g. " MiB" or "%"
+	Color     string     // color of the mapping, fixed color or threshold step, e.g. "red"
+	Threshold *Threshold // the active threshold step of numeric values
+	Mapped    bool       // whether a value mapping applied
+}
+
+// String returns the value as shown in the panel, e.g. "87%".
+func (v DisplayValue) String() string {
+	return v.Prefix + v.Text + v.Suffix
+}
+
+// FieldConfigFor returns the config of field i of a frame of the given refId: the config
+// the datasource set on the field, the panel defaults and the matching overrides.
+func (r Results) FieldConfigFor(ref string, frame Frame, i int) FieldConfig {
+	return newDisplayNamer(r).fieldConfig(ref, frame, i)
+}
+
+// Display renders a value of the field. Values may be numbers, strings, booleans or
+// nil for nulls. Numbers are formatted with the unit and decimals of the config, and
+// value mappings are applied in order, the first match winning. The color is the
+// color of the mapping, else the fixed color, else the color of the threshold step.
+func (c FieldConfig) Display(value any) DisplayValue {
+	var v DisplayValue
+
+	number, isNumber := toFloat64(value)
+	switch {
+	case value == nil:
+		v.Text = c.NoValue
+	case isNumber:
+		v.Prefix, v.Text, v.Suffix = FormatUnit(c.Unit, number, c.Decimals)
+	default:
+		v.Text = fmt.Sprint(value)
+	}
+
+	if isNumber && c.Thresholds != nil {
+		v.Threshold = c.Thresholds.Active(number, c.Min, c.Max)
+		if v.Threshold != nil {
+			v.Color = v.Threshold.Color
+		}
+	}
+	if c.Color != nil && c.Color.Mode == "fixed" && c.Color.FixedColor != "" {
+		v.Color = c.Color.FixedColor
+	}
+
+	for _, mapping := range c.Mappings {
+		result, ok := mapping.apply(value)
+		if !ok {
+			continue
+		}
+		v.Mapped = true
+		if result.Text != "" {
+			v.Prefix, v.Text, v.Suffix = "", result.Text, ""
+		}
+		if result.Color != "" {
+			v.Color = result.Color
+		}
+		break
+	}
+
+	return v
+}
+
+// Active returns the step a value falls into, or nil if there are no steps. Steps of
+// percentage thresholds are relative to min and max, which default to 0 and 100.
+func (t ThresholdsConfig) Active(value float64, min, max *float64) *Threshold {
+	if t.Mode == ThresholdsPercentage {
+		lo, hi := 0.0, 100.0
+		if min != nil {
+			lo = *min
+		}
+		if max != nil {
+			hi = *max
+		}
+		if hi != lo {
+			value = (value - lo) / (hi - lo) * 100
+		}
+	}
+
+	var active *Threshold
+	for i := range t.Steps {
+		step := &t.Steps[i]
+		if step.Value == nil || value >= *step.Value {
+			active = step
+		}
+	}
+	return active
+}
+
+// apply returns the result of the mapping if it matches the value.
+func (m ValueMapping) apply(value any) (ValueMappingResult, bool) {
+	number, isNumber := toFloat64(value)
+	switch m.Type {
+	case MappingValue:
+		if value == nil {
+			return ValueMappingResult{}, false
+		}
+		result, ok := m.Values[mappingKey(value)]
+		return result, ok
+	case MappingRange:
+		if !isNumber || math.IsNaN(number) {
+			return ValueMappingResult{}, false
+		}
+		if m.From != nil && number < *m.From || m.To != nil && number > *m.To {
+			return ValueMappingResult{}, false
+		}
+		return m.Result, m.From != nil || m.To != nil
+	case MappingRegex:
+		if value == nil || m.Pattern == "" {
+			return ValueMappingResult{}, false
+		}
+		re, err := compilePattern(m.Pattern)
+		if err != nil || !re.MatchString(mappingKey(value)) {
+			return ValueMappingResult{}, false
+		}
+		result := m.Result
+		if result.Text != "" {
+			result.Text = re.ReplaceAllString(mappingKey(value), result.Text)
+		}
+		return result, true
+	case MappingSpecial:
+		nan := isNumber && math.IsNaN(number)
+		matched := false
+		switch m.Match {
+		case "null":
+			matched = value == nil
+		case "nan":
+			matched = nan
+		case "null+nan":
+			matched = value == nil || nan
+		case "true":
+			matched = value == true
+		case "false":
+			matched = value == false
+		case "empty":
+			matched = value == ""
+		}
+		return m.Result, matched
+	}
+	return ValueMappingResult{}, false
+}
+
+// mappingKey formats a value the way value mappings store their keys.
+func mappingKey(value any) string {
+	if number, ok := toFloat64(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// compilePattern compiles a pattern written as a regex or, like in javascript, as /regex/flags.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			flags := strings.Map(func(r rune) rune {
+				if strings.ContainsRune("ims", r) {
+					return r
+				}
+				return -1
+			}, pattern[end+1:])
+			pattern = pattern[1:end]
+			if flags != "" {
+				pattern = "(?" + flags + ")" + pattern
+			}
+		}
+	}
+	return regexp.Compile(pattern)
+}
+
+// toFloat64 returns the numeric value of numbers.
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// UnmarshalJSON decodes value mappings, including the flat format of dashboards from
+// before Grafana 8, e.g. {"type": 1, "value": "1", "text": "Up"}.
+func (m *ValueMapping) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Type    any             `json:"type"`
+		Options json.RawMessage `json:"options"`
+
+		// flat format
+		Value string `json:"value"`
+		Text  string `json:"text"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*m = ValueMapping{}
+	switch t := raw.Type.(type) {
+	case float64:
+		switch t {
+		case 1:
+			m.Type = MappingValue
+			m.Values = map[string]ValueMappingResult{raw.Value: {Text: raw.Text}}
+		case 2:
+			m.Type = MappingRange
+			m.From, m.To = parseBound(raw.From), parseBound(raw.To)
+			m.Result = ValueMappingResult{Text: raw.Text}
+		}
+		return nil
+	case string:
+		m.Type = t
+	}
+	if len(raw.Options) == 0 {
+		return nil
+	}
+
+	switch m.Type {
+	case MappingValue:
+		return json.Unmarshal(raw.Options, &m.Values)
+	default:
+		var options struct {
+			From    *float64           `json:"from"`
+			To      *float64           `json:"to"`
+			Pattern string             `json:"pattern"`
+			Match   string             `json:"match"`
+			Result  ValueMappingResult `json:"result"`
+		}
+		if err := json.Unmarshal(raw.Options, &options); err != nil {
+			return err
+		}
+		m.From, m.To = options.From, options.To
+		m.Pattern, m.Match, m.Result = options.Pattern, options.Match, options.Result
+	}
+	return nil
+}
+
+// MarshalJSON encodes the mapping in the format of current Grafana versions.
+func (m ValueMapping) MarshalJSON() ([]byte, error) {
+	var options any
+	switch m.Type {
+	case MappingValue:
+		options = m.Values
+	case MappingRange:
+		options = map[string]any{"from": m.From, "to": m.To, "result": m.Result}
+	case MappingRegex:
+		options = map[string]any{"pattern": m.Pattern, "result": m.Result}
+	case MappingSpecial:
+		options = map[string]any{"match": m.Match, "result": m.Result}
+	}
+	return json.Marshal(map[string]any{"type": m.Type, "options": options})
+}
+
+// parseBound parses a range bound of the flat mapping format, empty is unbounded.
+func parseBound(s string) *float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// matches reports whether the matcher selects field i of a frame of the given refId,
+// whose name before overrides is name.
+func (m FieldMatcher) matches(ref string, frame Frame, i int, name string) bool {
+	field := frameField(frame, i)
+	switch m.ID {
+	case "byName":
+		option, _ := m.Options.(string)
+		return option != "" && (option == name || option == field.Name)
+	case "byNames":
+		options, _ := m.Options.(map[string]any)
+		names, _ := options["names"].([]any)
+		for _, n := range names {
+			if n == name || n == field.Name {
+				return true
+			}
+		}
+	case "byRegexp":
+		option, _ := m.Options.(string)
+		re, err := regexp.Compile("^(?:" + option + ")$")
+		return option != "" && err == nil && re.MatchString(name)
+	case "byFrameRefID":
+		option, _ := m.Options.(string)
+		return option == ref || option != "" && option == frame.Schema.RefId
+	case "byType":
+		option, _ := m.Options.(string)
+		return option == field.Type
+	}
+	return false
+}
+
+// applyDefaults sets the values that are not set from the panel defaults.
+func (c *FieldConfig) applyDefaults(defaults FieldConfig) {
+	if c.DisplayName == "" {
+		c.DisplayName = defaults.DisplayName
+	}
+	if c.DisplayNameFromDS == "" {
+		c.DisplayNameFromDS = defaults.DisplayNameFromDS
+	}
+	if c.Unit == "" {
+		c.Unit = defaults.Unit
+	}
+	if c.Decimals == nil {
+		c.Decimals = defaults.Decimals
+	}
+	if c.Min == nil {
+		c.Min = defaults.Min
+	}
+	if c.Max == nil {
+		c.Max = defaults.Max
+	}
+	if c.Thresholds == nil {
+		c.Thresholds = defaults.Thresholds
+	}
+	if c.Mappings == nil {
+		c.Mappings = defaults.Mappings
+	}
+	if c.Color == nil {
+		c.Color = defaults.Color
+	}
+	if c.NoValue == "" {
+		c.NoValue = defaults.NoValue
+	}
+	if len(defaults.Custom) > 0 {
+		custom := make(map[string]any, len(defaults.Custom)+len(c.Custom))
+		for k, v := range defaults.Custom {
+			custom[k] = v
+		}
+		for k, v := range c.Custom {
+			custom[k] = v
+		}
+		c.Custom = custom
+	}
+}
+
+// setProperty sets a value of an override. Unknown properties are ignored, as are
+// values that cannot be decoded.
+func (c *FieldConfig) setProperty(id string, value any) {
+	switch id {
+	case "displayName":
+		c.DisplayName, _ = value.(string)
+	case "displayNameFromDS":
+		c.DisplayNameFromDS, _ = value.(string)
+	case "unit":
+		c.Unit, _ = value.(string)
+	case "noValue":
+		c.NoValue, _ = value.(string)
+	case "decimals":
+		c.Decimals = nil
+		if f, ok := value.(float64); ok {
+			d := int(f)
+			c.Decimals = &d
+		}
+	case "min":
+		c.Min = nil
+		if f, ok := value.(float64); ok {
+			c.Min = &f
+		}
+	case "max":
+		c.Max = nil
+		if f, ok := value.(float64); ok {
+			c.Max = &f
+		}
+	case "thresholds":
+		var thresholds ThresholdsConfig
+		if decodeProperty(value, &thresholds) {
+			c.Thresholds = &thresholds
+		}
+	case "mappings":
+		var mappings []ValueMapping
+		if decodeProperty(value, &mappings) {
+			c.Mappings = mappings
+		}
+	case "color":
+		var color FieldColor
+		if decodeProperty(value, &color) {
+			c.Color = &color
+		}
+	default:
+		if key, ok := strings.CutPrefix(id, "custom."); ok {
+			custom := make(map[string]any, len(c.Custom)+1)
+			for k, v := range c.Custom {
+				custom[k] = v
+			}
+			custom[key] = value
+			c.Custom = custom
+		}
+	}
+}
+
+// decodeProperty decodes the JSON value of an override property into out.
+func decodeProperty(value any, out any) bool {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, out) == nil
+}
+
+// interpolate replaces the dashboard variables of the display names of the config.
+// The ${__field.*} references are left for the display name of each field.
+func (s FieldConfigSource) interpolate(ip *Interpolator) FieldConfigSource {
+	s.Defaults.DisplayName = ip.Interpolate(s.Defaults.DisplayName, "")
+
+	overrides := make([]FieldOverride, len(s.Overrides))
+	for i, override := range s.Overrides {
+		properties := make([]FieldProperty, len(override.Properties))
+		for j, property := range override.Properties {
+			if value, ok := property.Value.(string); ok && property.ID == "displayName" {
+				property.Value = ip.Interpolate(value, "")
+			}
+			properties[j] = property
+		}
+		override.Properties = properties
+		overrides[i] = override
+	}
+	if s.Overrides != nil {
+		s.Overrides = overrides
+	}
+
+	return s
+}
+
+// frameFieldConfig decodes the config a datasource set on a field.
+func frameFieldConfig(field Field) FieldConfig {
+	var config FieldConfig
+	if len(field.Config) == 0 {
+		return config
+	}
+	if b, err := json.Marshal(field.Config); err == nil {
+		_ = json.Unmarshal(b, &config)
+	}
+	return config
+}
@@ -0,0 +1,574 @@
+package grafanadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+//////////////////////////////////////////////////
+// Typed columns decoded from Grafana data frames
+//////////////////////////////////////////////////
+
+// FieldKind is the Go representation used for the values of a data frame field.
+type FieldKind string
+
+const (
+	KindTime    FieldKind = "time"
+	KindFloat64 FieldKind = "float64"
+	KindInt64   FieldKind = "int64"
+	KindString  FieldKind = "string"
+	KindBool    FieldKind = "bool"
+	KindJSON    FieldKind = "json"
+)
+
+// Kind returns the column kind for the field based on its type and typeInfo.
+func (f Field) Kind() FieldKind {
+	frameType, _ := f.TypeInfo["frame"].(string)
+	frameType = strings.TrimPrefix(frameType, "*")
+
+	switch f.Type {
+	case "time":
+		return KindTime
+	case "number", "enum":
+		switch frameType {
+		case "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "enum":
+			return KindInt64
+		}
+		return KindFloat64
+	case "string":
+		return KindString
+	case "boolean":
+		return KindBool
+	case "other":
+		return KindJSON
+	}
+
+	switch frameType {
+	case "time.Time":
+		return KindTime
+	case "string":
+		return KindString
+	case "bool":
+		return KindBool
+	case "json.RawMessage":
+		return KindJSON
+	}
+
+	return KindFloat64
+}
+
+// Nullable reports whether the field declares that its values may be null.
+func (f Field) Nullable() bool {
+	if nullable, ok := f.TypeInfo["nullable"].(bool); ok {
+		return nullable
+	}
+	frameType, _ := f.TypeInfo["frame"].(string)
+	return strings.HasPrefix(frameType, "*")
+}
+
+// Column holds the values of a single field. Only the slice matching Kind is populated.
+// Null rows hold the zero value and are flagged in Nulls.
+type Column struct {
+	Kind    FieldKind
+	Times   []time.Time
+	Floats  []float64
+	Ints    []int64
+	Strings []string
+	Bools   []bool
+	JSON    []json.RawMessage
+	Nulls   []bool // nil when the column contains no nulls
+}
+
+// Len returns the number of rows in the column.
+func (c Column) Len() int {
+	switch c.Kind {
+	case KindTime:
+		return len(c.Times)
+	case KindFloat64:
+		return len(c.Floats)
+	case KindInt64:
+		return len(c.Ints)
+	case KindString:
+		return len(c.Strings)
+	case KindBool:
+		return len(c.Bools)
+	case KindJSON:
+		return len(c.JSON)
+	}
+	return 0
+}
+
+// IsNull reports whether row i is null.
+func (c Column) IsNull(i int) bool {
+	return i < len(c.Nulls) && c.Nulls[i]
+}
+
+// Float64 returns row i as a float64. Times are returned as epoch milliseconds and
+// booleans as 0 or 1. It returns false for null rows and non-numeric kinds.
+func (c Column) Float64(i int) (float64, bool) {
+	if i < 0 || i >= c.Len() || c.IsNull(i) {
+		return 0, false
+	}
+
+	switch c.Kind {
+	case KindTime:
+		return float64(c.Times[i].UnixNano()) / 1e6, true
+	case KindFloat64:
+		return c.Floats[i], true
+	case KindInt64:
+		return float64(c.Ints[i]), true
+	case KindBool:
+		if c.Bools[i] {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// Float64s returns the column as float64 values with null rows set to NaN.
+func (c Column) Float64s() []float64 {
+	values := make([]float64, c.Len())
+	for i := range values {
+		v, ok := c.Float64(i)
+		if !ok {
+			v = math.NaN()
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// Value returns row i as an interface value, or nil when the row is null.
+func (c Column) Value(i int) any {
+	if i < 0 || i >= c.Len() || c.IsNull(i) {
+		return nil
+	}
+
+	switch c.Kind {
+	case KindTime:
+		return c.Times[i]
+	case KindFloat64:
+		return c.Floats[i]
+	case KindInt64:
+		return c.Ints[i]
+	case KindString:
+		return c.Strings[i]
+	case KindBool:
+		return c.Bools[i]
+	case KindJSON:
+		return c.JSON[i]
+	}
+	return nil
+}
+
+// fieldEntities lists the rows of a field that hold values JSON cannot represent.
+type fieldEntities struct {
+	NaN       []int `json:"NaN,omitempty"`
+	Inf       []int `json:"Inf,omitempty"`
+	NegInf    []int `json:"NegInf,omitempty"`
+	Undefined []int `json:"Undefined,omitempty"`
+}
+
+type rawData struct {
+	Values   []json.RawMessage `json:"values"`
+	Entities []*fieldEntities  `json:"entities,omitempty"`
+	Nanos    [][]int64         `json:"nanos,omitempty"`
+}
+
+func (d rawData) column(i int, field *Field) (Column, error) {
+	var entities *fieldEntities
+	if i < len(d.Entities) {
+		entities = d.Entities[i]
+	}
+	var nanos []int64
+	if i < len(d.Nanos) {
+		nanos = d.Nanos[i]
+	}
+
+	col, err := decodeColumn(d.Values[i], field, entities, nanos)
+	if err != nil {
+		return col, fmt.Errorf("failed to decode values of field %v: %w", i, err)
+	}
+	return col, nil
+}
+
+// UnmarshalJSON decodes the frame and types every column using its schema field.
+func (f *Frame) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Schema Schema  `json:"schema"`
+		Data   rawData `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	f.Schema = raw.Schema
+	f.Data = Data{}
+	for i := range raw.Data.Values {
+		var field *Field
+		if i < len(raw.Schema.Fields) {
+			field = &raw.Schema.Fields[i]
+		}
+		col, err := raw.Data.column(i, field)
+		if err != nil {
+			return err
+		}
+		f.Data.Values = append(f.Data.Values, col)
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes data without a schema, inferring each column's kind from its values.
+func (d *Data) UnmarshalJSON(b []byte) error {
+	var raw rawData
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	d.Values = nil
+	for i := range raw.Values {
+		col, err := raw.column(i, nil)
+		if err != nil {
+			return err
+		}
+		d.Values = append(d.Values, col)
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the data in Grafana's data frame format, moving NaN and
+// infinite values into entities.
+func (d Data) MarshalJSON() ([]byte, error) {
+	raw := struct {
+		Values   []any            `json:"values"`
+		Entities []*fieldEntities `json:"entities,omitempty"`
+	}{
+		Values: make([]any, len(d.Values)),
+	}
+
+	hasEntities := false
+	entities := make([]*fieldEntities, len(d.Values))
+	for i, col := range d.Values {
+		values := make([]any, col.Len())
+		for row := range values {
+			v := col.Value(row)
+			switch x := v.(type) {
+			case time.Time:
+				v = x.UnixMilli()
+			case float64:
+				if math.IsNaN(x) || math.IsInf(x, 0) {
+					if entities[i] == nil {
+						entities[i] = &fieldEntities{}
+					}
+					switch {
+					case math.IsNaN(x):
+						entities[i].NaN = append(entities[i].NaN, row)
+					case x > 0:
+						entities[i].Inf = append(entities[i].Inf, row)
+					default:
+						entities[i].NegInf = append(entities[i].NegInf, row)
+					}
+					hasEntities = true
+					v = nil
+				}
+			}
+			values[row] = v
+		}
+		raw.Values[i] = values
+	}
+	if hasEntities {
+		raw.Entities = entities
+	}
+
+	return json.Marshal(raw)
+}
+
+func decodeColumn(b json.RawMessage, field *Field, entities *fieldEntities, nanos []int64) (Column, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var values []any
+	if err := dec.Decode(&values); err != nil {
+		return Column{}, err
+	}
+
+	var kind FieldKind
+	if field != nil {
+		kind = field.Kind()
+	} else {
+		kind = inferKind(values)
+	}
+
+	col := Column{Kind: kind}
+	setNull := func(i int) {
+		if col.Nulls == nil {
+			col.Nulls = make([]bool, len(values))
+		}
+		col.Nulls[i] = true
+	}
+
+	switch kind {
+	case KindTime:
+		col.Times = make([]time.Time, len(values))
+	case KindFloat64:
+		col.Floats = make([]float64, len(values))
+	case KindInt64:
+		col.Ints = make([]int64, len(values))
+	case KindString:
+		col.Strings = make([]string, len(values))
+	case KindBool:
+		col.Bools = make([]bool, len(values))
+	case KindJSON:
+		col.JSON = make([]json.RawMessage, len(values))
+	}
+
+	for i, v := range values {
+		if v == nil {
+			setNull(i)
+			continue
+		}
+
+		switch kind {
+		case KindTime:
+			t, err := toTime(v)
+			if err != nil {
+				return col, err
+			}
+			if i < len(nanos) {
+				t = t.Add(time.Duration(nanos[i]))
+			}
+			col.Times[i] = t
+		case KindFloat64:
+			n, ok := v.(json.Number)
+			if !ok {
+				return col, fmt.Errorf("expected number at row %v, got %T", i, v)
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return col, err
+			}
+			col.Floats[i] = f
+		case KindInt64:
+			n, ok := v.(json.Number)
+			if !ok {
+				return col, fmt.Errorf("expected number at row %v, got %T", i, v)
+			}
+			x, err := n.Int64()
+			if err != nil {
+				f, ferr := n.Float64()
+				if ferr != nil {
+					return col, err
+				}
+				x = int64(f)
+			}
+			col.Ints[i] = x
+		case KindString:
+			s, ok := v.(string)
+			if !ok {
+				s = fmt.Sprint(v)
+			}
+			col.Strings[i] = s
+		case KindBool:
+			bv, ok := v.(bool)
+			if !ok {
+				return col, fmt.Errorf("expected bool at row %v, got %T", i, v)
+			}
+			col.Bools[i] = bv
+		case KindJSON:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return col, err
+			}
+			col.JSON[i] = raw
+		}
+	}
+
+	if entities != nil && kind == KindFloat64 {
+		apply := func(rows []int, value float64) {
+			for _, row := range rows {
+				if row >= 0 && row < len(col.Floats) {
+					col.Floats[row] = value
+					col.Nulls[row] = false
+				}
+			}
+		}
+		if col.Nulls != nil {
+			apply(entities.NaN, math.NaN())
+			apply(entities.Inf, math.Inf(1))
+			apply(entities.NegInf, math.Inf(-1))
+			// undefined values stay null
+		}
+	}
+
+	return col, nil
+}
+
+func toTime(v any) (time.Time, error) {
+	switch x := v.(type) {
+	case json.Number:
+		ms, err := x.Int64()
+		if err != nil {
+			f, ferr := x.Float64()
+			if ferr != nil {
+				return time.Time{}, err
+			}
+			return time.UnixMicro(int64(f * 1000)), nil
+		}
+		return time.UnixMilli(ms), nil
+	case string:
+		return time.Parse(time.RFC3339Nano, x)
+	}
+	return time.Time{}, fmt.Errorf("unexpected time value %T", v)
+}
+
+func inferKind(values []any) FieldKind {
+	for _, v := range values {
+		switch v.(type) {
+		case nil:
+			continue
+		case json.Number:
+			return KindFloat64
+		case string:
+			return KindString
+		case bool:
+			return KindBool
+		default:
+			return KindJSON
+		}
+	}
+	return KindFloat64
+}
+
+// Rows returns the number of rows of the frame.
+func (f Frame) Rows() int {
+	if len(f.Data.Values) == 0 {
+		return 0
+	}
+	return f.Data.Values[0].Len()
+}
+
+// appendValue appends a row, converting the value to the kind of the column. nil and
+// values that cannot be converted append a null row.
+func (c *Column) appendValue(v any) {
+	n := c.Len()
+	ok := false
+	switch c.Kind {
+	case KindTime:
+		var t time.Time
+		switch x := v.(type) {
+		case time.Time:
+			t, ok = x, true
+		default:
+			var ms float64
+			if ms, ok = toFloat64(v); ok {
+				t = time.UnixMilli(int64(ms))
+			}
+		}
+		c.Times = append(c.Times, t)
+	case KindFloat64:
+		var f float64
+		if t, isTime := v.(time.Time); isTime {
+			f, ok = float64(t.UnixMilli()), true
+		} else {
+			f, ok = toFloat64(v)
+		}
+		c.Floats = append(c.Floats, f)
+	case KindInt64:
+		var f float64
+		f, ok = toFloat64(v)
+		c.Ints = append(c.Ints, int64(f))
+	case KindString:
+		var s string
+		if s, ok = v.(string); !ok && v != nil {
+			s, ok = fmt.Sprint(v), true
+		}
+		c.Strings = append(c.Strings, s)
+	case KindBool:
+		var b bool
+		b, ok = v.(bool)
+		c.Bools = append(c.Bools, b)
+	case KindJSON:
+		var raw json.RawMessage
+		if raw, ok = v.(json.RawMessage); !ok && v != nil {
+			var err error
+			raw, err = json.Marshal(v)
+			ok = err == nil
+		}
+		c.JSON = append(c.JSON, raw)
+	}
+
+	switch {
+	case !ok:
+		if c.Nulls == nil {
+			c.Nulls = make([]bool, n, n+1)
+		}
+		c.Nulls = append(c.Nulls, true)
+	case c.Nulls != nil:
+		c.Nulls = append(c.Nulls, false)
+	}
+}
+
+// take returns the given rows of the column, a negative row giving a null row.
+func (c Column) take(rows []int) Column {
+	taken := Column{Kind: c.Kind}
+	for _, row := range rows {
+		if row < 0 {
+			taken.appendValue(nil)
+		} else {
+			taken.appendValue(c.Value(row))
+		}
+	}
+	return taken
+}
+
+// columnOf builds a column from values, its kind inferred from the first non-null value.
+func columnOf(values []any) Column {
+	col := Column{Kind: KindFloat64}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		switch v.(type) {
+		case time.Time:
+			col.Kind = KindTime
+		case string:
+			col.Kind = KindString
+		case bool:
+			col.Kind = KindBool
+		case json.RawMessage:
+			col.Kind = KindJSON
+		default:
+			if _, ok := toFloat64(v); !ok {
+				col.Kind = KindJSON
+			}
+		}
+		break
+	}
+	for _, v := range values {
+		col.appendValue(v)
+	}
+	return col
+}
+
+// fieldType returns the frame field type of a column kind.
+func fieldType(kind FieldKind) string {
+	switch kind {
+	case KindTime:
+		return "time"
+	case KindFloat64, KindInt64:
+		return "number"
+	case KindString:
+		return "string"
+	case KindBool:
+		return "boolean"
+	}
+	return "other"
+}
@@ -2,7 +2,9 @@ package grafanadata
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,20 +42,35 @@ type timeRange struct {
 type PanelOption func(*panelOptions)
 
 type panelOptions struct {
-	timerange timeRange
-	variables map[string]string
+	timerange           timeRange
+	variables           map[string][]string
+	queryErrorFatal     bool
+	ignoreTimeOverrides bool
+	includeHidden       bool
+	transform           bool
+	transformErrorFatal bool
 }
 
-func (o *panelOptions) applyVariables(s string) string {
-	for k, v := range o.variables {
-		s = strings.ReplaceAll(s, "$"+k, v)
+// interpolator returns an Interpolator for the selected variables using the metadata of the dashboard variables.
+// Variables that were not set explicitly default to the current value saved with the dashboard.
+func (o *panelOptions) interpolator(list []TemplateVariable) *Interpolator {
+	selected := make(map[string][]string, len(list))
+	for _, tpl := range list {
+		if current := tpl.Current.Values(); len(current) > 0 {
+			selected[tpl.Name] = current
+		}
+	}
+	for name, values := range o.variables {
+		selected[name] = values
 	}
 
-	return s
+	return newDashboardInterpolator(list, selected)
 }
 
 func newPanelOptions(opts ...PanelOption) panelOptions {
-	var options panelOptions
+	options := panelOptions{
+		variables: map[string][]string{},
+	}
 	for _, opt := range opts {
 		opt(&options)
 	}
@@ -72,19 +90,74 @@ func WithTimeRange(start, end time.Time) func(*panelOptions) {
 // WithVariables sets the variables for the panel query.
 func WithVariables(vars map[string]string) func(*panelOptions) {
 	return func(o *panelOptions) {
-		o.variables = vars
+		for k, v := range vars {
+			o.variables[k] = []string{v}
+		}
+	}
+}
+
+// WithMultiValueVariables sets variables that may hold several values for the panel query.
+// Use "$__all" as the only value to select the "All" option of a variable.
+func WithMultiValueVariables(vars map[string][]string) func(*panelOptions) {
+	return func(o *panelOptions) {
+		for k, v := range vars {
+			o.variables[k] = v
+		}
+	}
+}
+
+// WithQueryErrorsFatal makes panel queries fail with a *PartialResultError when any
+// query in the response reports an error, instead of only recording it on Results.
+func WithQueryErrorsFatal() func(*panelOptions) {
+	return func(o *panelOptions) {
+		o.queryErrorFatal = true
+	}
+}
+
+// WithoutPanelTimeOverrides ignores the relative time (timeFrom) and time shift (timeShift)
+// configured on the panel and queries the dashboard or WithTimeRange window as is.
+func WithoutPanelTimeOverrides() func(*panelOptions) {
+	return func(o *panelOptions) {
+		o.ignoreTimeOverrides = true
 	}
 }
 
 // GrafanaClient interface defines the methods that our Client will implement.
 type GrafanaClient interface {
 	NewRequest(method, endpoint string, body io.Reader) (*http.Request, error)
+	NewRequestWithContext(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error)
 	Do(req *http.Request) (*http.Response, error)
 	GetDashboard(uid string) (DashboardResponse, error)
+	GetDashboardContext(ctx context.Context, uid string) (DashboardResponse, error)
 	GetDashboardVariables(response DashboardResponse, opts ...PanelOption) (map[string][]string, error)
+	GetDashboardVariablesContext(ctx context.Context, response DashboardResponse, opts ...PanelOption) (map[string][]string, error)
+	ResolveVariables(response DashboardResponse, opts ...PanelOption) (VariableSet, error)
+	ResolveVariablesContext(ctx context.Context, response DashboardResponse, opts ...PanelOption) (VariableSet, error)
 	GetPanelDataFromID(uid string, panelID int, opts ...PanelOption) (Results, error)
+	GetPanelDataFromIDContext(ctx context.Context, uid string, panelID int, opts ...PanelOption) (Results, error)
+	GetRepeatedPanelData(uid string, panelID int, opts ...PanelOption) ([]RepeatedResults, error)
+	GetRepeatedPanelDataContext(ctx context.Context, uid string, panelID int, opts ...PanelOption) ([]RepeatedResults, error)
+	ExpandRepeats(dashboard DashboardResponse, opts ...PanelOption) ([]RepeatedPanel, error)
+	ExpandRepeatsContext(ctx context.Context, dashboard DashboardResponse, opts ...PanelOption) ([]RepeatedPanel, error)
 	FetchDashboards() ([]DashboardSearch, error)
+	FetchDashboardsContext(ctx context.Context) ([]DashboardSearch, error)
 	FetchPanelsFromDashboard(dashboard DashboardResponse) []PanelSearch
+	GetLibraryPanel(uid string) (LibraryElement, error)
+	GetLibraryPanelContext(ctx context.Context, uid string) (LibraryElement, error)
+	FetchLibraryPanels() ([]LibraryElement, error)
+	FetchLibraryPanelsContext(ctx context.Context) ([]LibraryElement, error)
+	SearchLibraryPanels(query string) ([]LibraryElement, error)
+	SearchLibraryPanelsContext(ctx context.Context, query string) ([]LibraryElement, error)
+	ListDatasources() ([]Datasource, error)
+	ListDatasourcesContext(ctx context.Context) ([]Datasource, error)
+	GetDatasourceByUID(uid string) (Datasource, error)
+	GetDatasourceByUIDContext(ctx context.Context, uid string) (Datasource, error)
+	GetDatasourceByName(name string) (Datasource, error)
+	GetDatasourceByNameContext(ctx context.Context, name string) (Datasource, error)
+	CheckDatasourceHealth(uid string) (DatasourceHealth, error)
+	CheckDatasourceHealthContext(ctx context.Context, uid string) (DatasourceHealth, error)
+	DashboardDatasources(dashboard DashboardResponse, opts ...PanelOption) ([]Datasource, error)
+	DashboardDatasourcesContext(ctx context.Context, dashboard DashboardResponse, opts ...PanelOption) ([]Datasource, error)
 	GetHost() string
 }
 
@@ -119,11 +192,17 @@ func WithLogger(logger Logger) ClientOption {
 
 // Client represents a Grafana client that can interact with the Grafana API.
 type Client struct {
-	baseURL           *url.URL
-	token             string
-	client            HTTPClient
-	log               Logger
-	defaultDatasource Datasource
+	baseURL       *url.URL
+	token         string
+	client        HTTPClient
+	log           Logger
+	retry         *RetryPolicy
+	datasourceTTL time.Duration
+	libraryTTL    time.Duration
+
+	mu              sync.Mutex
+	datasources     datasourceCache
+	libraryElements map[string]cachedLibraryElement // by uid
 }
 
 // NewGrafanaClient creates a new Grafana Client with an API token and returns the GrafanaClient interface
@@ -134,9 +213,11 @@ func NewGrafanaClient(urlstr string, opts ...ClientOption) (*Client, error) {
 	}
 
 	client := Client{
-		baseURL: parsed,
-		client:  &http.Client{},
-		log:     slog.Default(),
+		baseURL:       parsed,
+		client:        &http.Client{},
+		log:           slog.Default(),
+		datasourceTTL: DefaultDatasourceCacheTTL,
+		libraryTTL:    DefaultLibraryPanelCacheTTL,
 	}
 
 	for _, opt := range opts {
@@ -146,7 +227,7 @@ func NewGrafanaClient(urlstr string, opts ...ClientOption) (*Client, error) {
 	return &client, nil
 }
 
-func (c *Client) getDashboard(uid string) (DashboardResponse, error) {
+func (c *Client) getDashboard(ctx context.Context, uid string) (DashboardResponse, error) {
 	var response DashboardResponse
 
 	host := strings.TrimSuffix(c.baseURL.String(), "/")
@@ -154,7 +235,7 @@ func (c *Client) getDashboard(uid string) (DashboardResponse, error) {
 
 	c.log.Debug("getting dashboard", "host", host, "query", query)
 
-	req, err := c.NewRequest(http.MethodGet, query, nil)
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, query, nil)
 	if err != nil {
 		return response, fmt.Errorf("failed to get dashboard %v with error %w", uid, err)
 	}
@@ -173,8 +254,11 @@ func (c *Client) getDashboard(uid string) (DashboardResponse, error) {
 	c.log.Debug("got dashboard response", "status", resp.StatusCode, "body", string(b))
 
 	if resp.StatusCode != http.StatusOK {
-		return response, fmt.Errorf("grafana returned status %v; body: %s", resp.StatusCode,
-			string(b))
+		apiErr := newAPIError(req, resp.StatusCode, b)
+		if resp.StatusCode == http.StatusNotFound {
+			return response, fmt.Errorf("%w: %v: %w", ErrDashboardNotFound, uid, apiErr)
+		}
+		return response, apiErr
 	}
 
 	err = json.Unmarshal(b, &response)
@@ -185,59 +269,59 @@ func (c *Client) getDashboard(uid string) (DashboardResponse, error) {
 	return response, nil
 }
 
-// defaultIntervalMs is the fallback minimum interval (1 minute) used when a panel
-// has an interval configured but it cannot be parsed.
-const defaultIntervalMs = 60000
+// effectiveTimeRange returns the absolute range of a panel query. Bounds that were not
+// set with WithTimeRange are taken from the dashboard's time picker. The returned flag
+// reports whether the range is relative to now, as panel relative time overrides only
+// apply to relative ranges.
+func effectiveTimeRange(dashboard Dashboard, requested timeRange, now time.Time) (TimeRange, bool, error) {
+	from, to := dashboard.Time.From, dashboard.Time.To
+	if from == "" {
+		from = "now-6h"
+	}
+	if to == "" {
+		to = "now"
+	}
 
-// parseIntervalMs parses a Grafana interval string (e.g. "1m", "5m", "30s", "2h")
-// and returns the equivalent value in milliseconds.
-// Returns 0 if the interval string is empty (panel has no interval configured).
-// Returns defaultIntervalMs if the interval is non-empty but cannot be parsed,
-// to avoid silently dropping the panel's intended minimum interval.
-func parseIntervalMs(interval string) int {
-	if interval == "" {
-		return 0
+	tr, err := ParseTimeRange(from, to, now, dashboard.TimeOptions())
+	if err != nil && (requested.Start.IsZero() || requested.End.IsZero()) {
+		return tr, false, err
 	}
 
-	interval = strings.TrimSpace(interval)
-	if len(interval) < 2 {
-		return defaultIntervalMs
+	relative := requested.Start.IsZero() && strings.HasPrefix(strings.TrimSpace(from), "now")
+	if !requested.Start.IsZero() {
+		tr.From = requested.Start
+	}
+	if !requested.End.IsZero() {
+		tr.To = requested.End
 	}
 
-	unit := interval[len(interval)-1]
-	valueStr := interval[:len(interval)-1]
+	return tr, relative, nil
+}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultIntervalMs
-	}
-
-	switch unit {
-	case 's':
-		return value * 1000
-	case 'm':
-		return value * 60 * 1000
-	case 'h':
-		return value * 60 * 60 * 1000
-	case 'd':
-		return value * 24 * 60 * 60 * 1000
-	default:
-		return defaultIntervalMs
+// retrieves the data for a panel in a dashboard.
+func (c *Client) getPanelData(ctx context.Context, panelID int, dashboard DashboardResponse, opts ...PanelOption) (Results, error) {
+	panel := dashboard.GetPanelByID(panelID)
+	if panel == nil {
+		return Results{}, fmt.Errorf("%w: %v in dashboard %v", ErrPanelNotFound, panelID, dashboard.Dashboard.ID)
 	}
+
+	c.log.Debug("got panel", "id", panelID, "panel", panel)
+
+	return c.queryPanel(ctx, panel, dashboard, opts...)
 }
 
-// retrieves the data for a panel in a dashboard.
-func (c *Client) getPanelData(panelID int, dashboard DashboardResponse, opts ...PanelOption) (Results, error) {
+// queryPanel queries the targets of a panel of the dashboard.
+func (c *Client) queryPanel(ctx context.Context, panel *Panel, dashboard DashboardResponse, opts ...PanelOption) (Results, error) {
 	var result Results
 
 	options := newPanelOptions(opts...)
+	panelID := panel.ID
 
-	panel := dashboard.GetPanelByID(panelID)
-	if panel == nil {
-		return result, fmt.Errorf("failed to find panel %v in dashboard %v", panelID, dashboard.Dashboard.ID)
+	resolved, err := c.resolveLibraryPanel(ctx, *panel)
+	if err != nil {
+		return result, fmt.Errorf("failed to resolve library panel of panel %v: %w", panelID, err)
 	}
-
-	c.log.Debug("got panel", "id", panelID, "panel", panel)
+	panel = &resolved
 
 	// Determine maxDataPoints: use panel-level value if set, otherwise use default.
 	maxDataPoints := defaultMaxDataPoints
@@ -245,80 +329,170 @@ func (c *Client) getPanelData(panelID int, dashboard DashboardResponse, opts ...
 		maxDataPoints = *panel.MaxDataPoints
 	}
 
-	// Parse the panel's minimum interval (e.g. "1m") to milliseconds for Grafana's
-	// $__interval and $__rate_interval resolution.
-	intervalMs := parseIntervalMs(panel.Interval)
-	if panel.Interval != "" && intervalMs == defaultIntervalMs {
-		c.log.Warn("could not parse panel interval, using default",
-			"panelID", panelID, "interval", panel.Interval, "defaultIntervalMs", defaultIntervalMs)
+	ip := options.interpolator(dashboard.Dashboard.Templating.List)
+
+	// the default datasource is only looked up when a target needs it
+	if !panel.Datasource.isEmpty() {
+		ds, err := c.resolveDatasource(ctx, panel.Datasource, ip)
+		if err != nil {
+			c.log.Warn("failed to resolve panel datasource", "panelID", panelID, "datasource", panel.Datasource, "error", err)
+		} else {
+			panel.Datasource = ds
+		}
+	}
+	if panel.Datasource.IsDashboard() {
+		return c.queryDashboardDatasource(ctx, panel, dashboard, opts...)
+	}
+
+	now := time.Now()
+	tr, relative, err := effectiveTimeRange(dashboard.Dashboard, options.timerange, now)
+	if err != nil {
+		return result, fmt.Errorf("failed to resolve time range: %w", err)
+	}
+
+	var timeInfo string
+	if !options.ignoreTimeOverrides {
+		tr, timeInfo, err = applyPanelTimeOverrides(*panel, tr, relative, now, dashboard.Dashboard.TimeOptions(), ip)
+		if err != nil {
+			c.log.Warn("ignoring invalid panel time override", "panelID", panelID,
+				"timeFrom", panel.TimeFrom, "timeShift", panel.TimeShift, "error", err)
+		}
 	}
 
+	// Compute intervalMs like Grafana does for $__interval and $__rate_interval resolution:
+	// the range divided by maxDataPoints, bounded by the panel's minimum interval
+	// (e.g. ">1m" or "$interval") or else the datasource's scrape interval.
+	interval := ip.Interpolate(panel.Interval, "")
+	var minInterval time.Duration
+	if !isAutoInterval(interval) {
+		d, err := ParseInterval(interval)
+		if err != nil {
+			c.log.Warn("could not parse panel interval, using default",
+				"panelID", panelID, "interval", panel.Interval, "defaultIntervalMs", defaultIntervalMs)
+			d = defaultIntervalMs * time.Millisecond
+		}
+		minInterval = d
+	} else if uid := panel.Datasource.UID; uid != "" && !strings.Contains(uid, "$") && !panel.Datasource.IsMixed() {
+		scrape, err := c.getScrapeInterval(ctx, uid)
+		if err != nil {
+			c.log.Debug("could not get datasource scrape interval", "panelID", panelID, "uid", uid, "error", err)
+		}
+		minInterval = scrape
+	}
+	intervalMs := CalculateInterval(tr, maxDataPoints, minInterval).Milliseconds()
+
 	c.log.Debug("panel query settings", "panelID", panelID,
 		"maxDataPoints", maxDataPoints, "interval", panel.Interval, "intervalMs", intervalMs)
 
+	targets := map[string]TargetOptions{}
+	var visible []string
+	for i := range panel.Targets {
+		t, ok := panel.Targets[i].(map[string]any)
+		if !ok {
+			c.log.Warn("skipping target that is not an object", "panelID", panelID, "target", panel.Targets[i])
+			continue
+		}
+		target := parseTargetOptions(t)
+		targets[target.RefID] = target
+		if !target.Hide || options.includeHidden {
+			visible = append(visible, target.RefID)
+		}
+	}
+	sqlExpressionInputs(targets)
+	// hidden queries are still sent when a visible expression reads them
+	needed := expressionInputs(targets, visible)
+
 	legends := map[string]string{}
+	var queries []any
 	for i := range panel.Targets {
-		t := panel.Targets[i].(map[string]any)
-		if _, ok := t["datasource"]; !ok {
-			// if the target has no datasource, use the panel's datasource
-			if panel.Datasource.UID == "" {
-				c.log.Debug("panel has no datasource, using default datasource", "panelID", panelID, "panel", panel)
-				datasource, err := c.getDefaultDatasource()
+		t, ok := panel.Targets[i].(map[string]any)
+		if !ok {
+			continue
+		}
+		target := parseTargetOptions(t)
+		if !needed[target.RefID] {
+			c.log.Debug("skipping hidden target", "panelID", panelID, "refId", target.RefID)
+			continue
+		}
+		queries = append(queries, t)
+
+		if target.Expression != "" {
+			// expressions are evaluated by grafana itself, whatever the panel datasource is
+			t["datasource"] = expressionDatasource()
+		} else if raw, ok := t["datasource"]; !ok || raw == nil {
+			// if the target has no datasource, use the panel's datasource. Targets of
+			// mixed panels without their own datasource use the default datasource.
+			ds := panel.Datasource
+			if ds.isEmpty() || ds.IsMixed() {
+				c.log.Debug("using default datasource for target", "panelID", panelID, "panel", panel)
+				datasource, err := c.getDefaultDatasource(ctx)
 				if err != nil {
 					c.log.Warn("failed to get default datasource", "error", err)
 				} else {
-					panel.Datasource = datasource
+					ds = datasource
 				}
 			}
 			c.log.Debug("target has no datasource, using panel datasource", "panelID", panelID, "target", t)
-			t["datasource"] = panel.Datasource
+			t["datasource"] = ds.ref()
+		} else if ref := datasourceRef(raw); ref.Name != "" || strings.Contains(ref.UID, "$") {
+			// legacy names and datasource variables
+			ds, err := c.resolveDatasource(ctx, ref, ip)
+			if err != nil {
+				c.log.Warn("failed to resolve target datasource", "panelID", panelID, "datasource", raw, "error", err)
+			} else {
+				t["datasource"] = ds.ref()
+			}
 		}
 		if expr, ok := t["expr"].(string); ok {
 			c.log.Debug("applying variables for target", "panelID", panelID,
 				"target", t, "expr", expr, "variables", options.variables)
-			t["expr"] = options.applyVariables(expr)
+			t["expr"] = ip.Interpolate(expr, datasourceType(t["datasource"]))
+			if opts, ok := targets[target.RefID]; ok {
+				opts.Expr = t["expr"].(string)
+				targets[target.RefID] = opts
+			}
 		}
-		if legend, ok := t["legendFormat"].(string); ok && legend != "__auto" {
+		if legend, ok := t["legendFormat"].(string); ok && legend != "" {
 			if ref, ok := t["refId"].(string); ok {
 				c.log.Debug("adding legend for target", "panelID", panelID, "target", t, "legend", legend)
-				legends[ref] = options.applyVariables(legend)
+				legends[ref] = ip.Interpolate(legend, "")
 			} else {
 				c.log.Warn("target has no refId, cannot set legend", "panelID", panelID,
 					"target", t, "legend", legend)
 			}
 		}
 
+		// the minimum interval of a target may reference a variable, e.g. "$min_interval"
+		if target.Interval != "" {
+			t["interval"] = ip.Interpolate(target.Interval, "")
+		}
+
 		// Inject maxDataPoints and intervalMs into each target so that Grafana resolves
 		// $__interval, $__rate_interval, and $__range identically to the dashboard UI.
 		if _, ok := t["maxDataPoints"]; !ok {
 			t["maxDataPoints"] = maxDataPoints
 		}
-		if intervalMs > 0 {
-			if _, ok := t["intervalMs"]; !ok {
-				t["intervalMs"] = intervalMs
+		// intervalFactor widens the interval, and so the step, of a target like in Grafana
+		if _, ok := t["intervalMs"]; !ok {
+			t["intervalMs"] = intervalMs
+			if target.IntervalFactor > 0 {
+				t["intervalMs"] = int64(float64(intervalMs) * target.IntervalFactor)
 			}
 		}
 	}
 
-	request := GrafanaDataQueryRequest{
-		Queries: panel.Targets,
+	if len(queries) == 0 {
+		c.log.Debug("panel has no visible targets, not querying", "panelID", panelID)
+		return Results{Results: map[string]Result{}, Range: tr, TimeInfo: timeInfo, c: c}, nil
 	}
 
-	if options.timerange.Start.IsZero() {
-		// use the dashboard's time range if not set
-		c.log.Debug("using dashboard time range for query", "dashboardID", dashboard.Dashboard.ID)
-		request.From = dashboard.Dashboard.Time.From
-	} else {
-		c.log.Debug("setting start time for query", "start", options.timerange.Start)
-		request.From = strconv.FormatInt(options.timerange.Start.Unix()*int64(1000), 10)
+	request := GrafanaDataQueryRequest{
+		Queries: queries,
 	}
 
-	if options.timerange.End.IsZero() {
-		request.To = "now"
-	} else {
-		c.log.Debug("setting end time for query", "end", options.timerange.End)
-		request.To = strconv.FormatInt(options.timerange.End.Unix()*int64(1000), 10)
-	}
+	c.log.Debug("setting time range for query", "from", tr.From, "to", tr.To)
+	request.From = strconv.FormatInt(tr.From.UnixMilli(), 10)
+	request.To = strconv.FormatInt(tr.To.UnixMilli(), 10)
 
 	b, err := json.Marshal(&request)
 	if err != nil {
@@ -327,7 +501,7 @@ func (c *Client) getPanelData(panelID int, dashboard DashboardResponse, opts ...
 
 	host := strings.TrimSuffix(c.baseURL.String(), "/")
 	query := fmt.Sprintf("%v/api/ds/query", host)
-	req, err := c.NewRequest(http.MethodPost, query, bytes.NewBuffer(b))
+	req, err := c.NewRequestWithContext(ctx, http.MethodPost, query, bytes.NewBuffer(b))
 	if err != nil {
 		return result, fmt.Errorf("failed to build request %w", err)
 	}
@@ -345,224 +519,141 @@ func (c *Client) getPanelData(panelID int, dashboard DashboardResponse, opts ...
 
 	c.log.Debug("got panel data response", "status", resp.StatusCode, "body", string(b))
 
-	if resp.StatusCode != http.StatusOK {
-		return result, fmt.Errorf("grafana returned status %v; body: %s", resp.StatusCode, string(b))
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if err := json.Unmarshal(b, &result); err != nil {
+			return result, fmt.Errorf("could not unmarshal response %w", err)
+		}
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusMultiStatus:
+		// failed queries may be answered with 400, or 207 on newer versions, and the
+		// usual results carrying the error of every refId, reported like those of a 200
+		var ok bool
+		if result, ok = queryResults(b); !ok {
+			return result, newAPIError(req, resp.StatusCode, b)
+		}
+	default:
+		return result, newAPIError(req, resp.StatusCode, b)
 	}
 
-	err = json.Unmarshal(b, &result)
+	// the results of hidden expression inputs are not part of the panel
+	result.Targets = map[string]TargetOptions{}
+	for _, ref := range visible {
+		result.Targets[ref] = targets[ref]
+	}
+	for ref := range needed {
+		if _, ok := result.Targets[ref]; !ok {
+			delete(result.Results, ref)
+		}
+	}
 
 	result.Legends = legends
+	result.FieldConfig = panel.FieldConfig.interpolate(ip)
+	result.ReduceOptions = panelReduceOptions(*panel)
+	result.Range = tr
+	result.TimeInfo = timeInfo
 	result.c = c
 
-	return result, err
+	if options.transform && len(panel.Transformations) > 0 {
+		transformed, err := result.Transform(panel.Transformations)
+		if err != nil {
+			c.log.Warn("failed to transform panel data", "panelID", panelID, "error", err)
+			if options.transformErrorFatal && !errors.Is(err, ErrUnsupportedTransformation) {
+				return result, err
+			}
+		}
+		result = transformed
+	}
+
+	// Grafana answers 200 even when single queries fail, so check every refId
+	if err := result.Err(); err != nil {
+		c.log.Warn("panel query returned errors", "panelID", panelID, "error", err)
+		if options.queryErrorFatal {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// queryResults decodes a /api/ds/query response that is not a 200, if it holds results.
+func queryResults(body []byte) (Results, bool) {
+	var results Results
+	if err := json.Unmarshal(body, &results); err != nil || len(results.Results) == 0 {
+		return Results{}, false
+	}
+	return results, true
 }
 
 // GetDashboard retrieves a dashboard object from a uid
 func (c *Client) GetDashboard(uid string) (DashboardResponse, error) {
-	return c.getDashboard(uid)
+	return c.GetDashboardContext(context.Background(), uid)
+}
+
+// GetDashboardContext retrieves a dashboard object from a uid using the provided context
+func (c *Client) GetDashboardContext(ctx context.Context, uid string) (DashboardResponse, error) {
+	return c.getDashboard(ctx, uid)
 }
 
 // GetPanelDataFromID retrieves the panel data from an id
 func (c *Client) GetPanelDataFromID(uid string, panelID int, opts ...PanelOption) (Results, error) {
+	return c.GetPanelDataFromIDContext(context.Background(), uid, panelID, opts...)
+}
+
+// GetPanelDataFromIDContext retrieves the panel data from an id using the provided context
+func (c *Client) GetPanelDataFromIDContext(ctx context.Context, uid string, panelID int, opts ...PanelOption) (Results, error) {
 	var result Results
 
-	dashboard, err := c.getDashboard(uid)
+	dashboard, err := c.getDashboard(ctx, uid)
 	if err != nil {
 		return result, err
 	}
 
-	result, err = c.getPanelData(panelID, dashboard, opts...)
+	result, err = c.getPanelData(ctx, panelID, dashboard, opts...)
 
 	return result, err
 }
 
 // GetPanelDataFromTitle retrieves the panel data from title
 func (c *Client) GetPanelDataFromTitle(uid string, title string, opts ...PanelOption) (Results, error) {
+	return c.GetPanelDataFromTitleContext(context.Background(), uid, title, opts...)
+}
+
+// GetPanelDataFromTitleContext retrieves the panel data from title using the provided context
+func (c *Client) GetPanelDataFromTitleContext(ctx context.Context, uid string, title string, opts ...PanelOption) (Results, error) {
 	var result Results
 
-	dashboard, err := c.getDashboard(uid)
+	dashboard, err := c.getDashboard(ctx, uid)
 	if err != nil {
 		return result, err
 	}
 
-	for i := range dashboard.Dashboard.Panels {
-		p := dashboard.Dashboard.Panels[i]
-		if p.Title != title {
-			continue
-		}
-		result, err = c.getPanelData(p.ID, dashboard, opts...)
-
-		return result, err
+	p := dashboard.GetPanelByTitle(title)
+	if p == nil {
+		return result, fmt.Errorf("%w: %q in dashboard %v", ErrPanelNotFound, title, uid)
 	}
 
-	return result, fmt.Errorf("failed to find panel %v", title)
+	return c.getPanelData(ctx, p.ID, dashboard, opts...)
 }
 
+// GetDashboardVariables resolves the options of every template variable of a dashboard.
+// Variables that include the "All" option list "$__all" as their first value.
 func (c *Client) GetDashboardVariables(response DashboardResponse, opts ...PanelOption) (map[string][]string, error) {
-	var result = make(map[string][]string)
-
-	options := newPanelOptions(opts...)
-
-	for _, tpl := range response.Dashboard.Templating.List {
-		if tpl.Type != "query" {
-			continue
-		}
-
-		queryMap, ok := tpl.Query.(map[string]any)
-		if !ok {
-			c.log.Warn("failed to convert query to map", "tpl", tpl)
-			continue
-		}
-
-		query, ok := queryMap["query"].(string)
-		if !ok {
-			c.log.Warn("failed to get query", "queryMap", queryMap)
-			continue
-		}
-		if tpl.Datasource.UID == "" {
-			c.log.Debug("template has no datasource, using default datasource", "template", tpl)
-
-			datasource, err := c.getDefaultDatasource()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get default datasource: %w", err)
-			} else {
-				tpl.Datasource = datasource
-			}
-		}
-
-		if strings.HasPrefix(query, "label_values(") {
-			// Handle label_values queries by calling Grafana's API
-			values, err := c.getLabelValues(tpl.Datasource.UID, query, options)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get label values for variable %s: %w", tpl.Name, err)
-			}
-			result[tpl.Name] = values
-			// for each value add new variable so that it can be used in queries, if not set
-			if options.variables[tpl.Name] == "" {
-				options.variables[tpl.Name] = strings.Join(values, "|")
-			}
-		} else {
-			// For other query types, you might want to handle them differently
-			c.log.Warn("unhandled query type", "tpl", tpl)
-		}
-	}
-
-	return result, nil
+	return c.GetDashboardVariablesContext(context.Background(), response, opts...)
 }
 
-// getLabelValues queries Grafana's label values API for label_values() queries
-func (c *Client) getLabelValues(ds, query string, options panelOptions) ([]string, error) {
-	// Extract metric and label from label_values(metric, label) format
-	query = strings.TrimPrefix(query, "label_values(")
-	query = strings.TrimSuffix(query, ")")
-
-	parts := strings.Split(query, ",")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("invalid label_values query format: %s", query)
-	}
-	if len(parts) != 2 {
-		// Handle case where metric contains commas
-		// Join all but the last part as the metric
-		parts = []string{strings.Join(parts[:len(parts)-1], ","), parts[len(parts)-1]}
-	}
-
-	metric := options.applyVariables(strings.TrimSpace(parts[0]))
-	label := strings.TrimSpace(parts[1])
-
-	host := strings.TrimSuffix(c.baseURL.String(), "/")
-	endpoint := fmt.Sprintf("%s/api/datasources/uid/%s/resources/"+
-		"api/v1/label/%s/values?match[]=%s&start=%d",
-		host, ds, label, url.QueryEscape(metric), options.timerange.Start.Unix())
-	if !options.timerange.End.IsZero() {
-		endpoint += fmt.Sprintf("&end=%d", options.timerange.End.Unix())
-	}
-
-	c.log.Debug("getting label values", "endpoint", endpoint, "metric", metric, "label", label)
-
-	req, err := c.NewRequest(http.MethodGet, endpoint, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := c.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("grafana returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
+func (c *Client) GetDashboardVariablesContext(ctx context.Context, response DashboardResponse, opts ...PanelOption) (map[string][]string, error) {
+	set, err := c.ResolveVariablesContext(ctx, response, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	var labelResponse struct {
-		Status string   `json:"status"`
-		Data   []string `json:"data"`
-	}
-
-	if err := json.Unmarshal(body, &labelResponse); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	if labelResponse.Status != "success" {
-		return nil, fmt.Errorf("grafana API returned status: %s", labelResponse.Status)
-	}
-
-	return labelResponse.Data, nil
+	return set.Options(), nil
 }
 
-func (c *Client) getDefaultDatasource() (Datasource, error) {
-	if c.defaultDatasource.UID != "" {
-		return c.defaultDatasource, nil
-	}
-
-	// fetch default datasource using api
-	host := strings.TrimSuffix(c.baseURL.String(), "/")
-	query := fmt.Sprintf("%v/api/datasources", host)
-
-	c.log.Debug("getting default datasource", "host", host, "query", query)
-
-	req, err := c.NewRequest(http.MethodGet, query, nil)
-	if err != nil {
-		return c.defaultDatasource, fmt.Errorf("failed to get datasources with error %w", err)
-	}
-
-	resp, err := c.Do(req)
-	if err != nil {
-		return c.defaultDatasource, fmt.Errorf("failed to get datasources with error %w", err)
-	}
-	defer resp.Body.Close()
-
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return c.defaultDatasource, fmt.Errorf("could not read response body with error %w", err)
-	}
-
-	c.log.Debug("got datasources response", "status", resp.StatusCode, "body", string(b))
-
-	if resp.StatusCode != http.StatusOK {
-		return c.defaultDatasource, fmt.Errorf("grafana returned status %v; body: %s", resp.StatusCode, string(b))
-	}
-
-	var datasources []Datasource
-	err = json.Unmarshal(b, &datasources)
-	if err != nil {
-		return c.defaultDatasource, fmt.Errorf("could not unmarshal response %w", err)
-	}
-
-	// Find the default datasource
-	for _, ds := range datasources {
-		if ds.IsDefault {
-			c.defaultDatasource = ds
-			break
-		}
-	}
-
-	return c.defaultDatasource, nil
+// datasourceType returns the type of a target datasource, which is either a
+// Datasource, its decoded JSON map or a legacy name.
+func datasourceType(ds any) string {
+	return datasourceRef(ds).Type
 }
 
 // ExtractArgs returns the uid and panel id from a url
@@ -1,12 +1,19 @@
 package grafanadata
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -61,7 +68,7 @@ func TestGetDashboard(t *testing.T) {
 
 	g := CreateMockGrafanaClient(t, client)
 
-	dashboard, err := g.getDashboard("foo")
+	dashboard, err := g.getDashboard(context.Background(), "foo")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,7 +81,7 @@ func TestGetDashboard(t *testing.T) {
 	client = CreateMockClient(t, "dashboard.json", http.StatusNotFound)
 
 	g = CreateMockGrafanaClient(t, client)
-	_, err = g.getDashboard("foo")
+	_, err = g.getDashboard(context.Background(), "foo")
 	if err == nil {
 		t.Fatal("wanted error but was nil")
 	}
@@ -86,7 +93,7 @@ func TestGetPanelData(t *testing.T) {
 
 	g := CreateMockGrafanaClient(t, client)
 
-	dashboard, err := g.getDashboard("foo")
+	dashboard, err := g.getDashboard(context.Background(), "foo")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -96,7 +103,7 @@ func TestGetPanelData(t *testing.T) {
 
 	g = CreateMockGrafanaClient(t, client)
 
-	data, err := g.getPanelData(2, dashboard, WithTimeRange(time.Now(), time.Time{}))
+	data, err := g.getPanelData(context.Background(), 2, dashboard, WithTimeRange(time.Now(), time.Time{}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,7 +155,7 @@ func TestGetDashboards(t *testing.T) {
 	client = CreateMockClient(t, "dashboard.json", http.StatusNotFound)
 
 	g = CreateMockGrafanaClient(t, client)
-	_, err = g.getDashboard("foo")
+	_, err = g.getDashboard(context.Background(), "foo")
 	if err == nil {
 		t.Fatal("wanted error but was nil")
 	}
@@ -205,12 +212,122 @@ func TestParseIntervalMs(t *testing.T) {
 	}
 }
 
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"500ms", 500 * time.Millisecond},
+		{"15s", 15 * time.Second},
+		{"1w", 7 * 24 * time.Hour},
+		{"1M", 30 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{">1m", time.Minute},
+		{"10", 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := ParseInterval(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != tt.expected {
+				t.Errorf("ParseInterval(%q) = %v, want %v", tt.input, d, tt.expected)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "m", "1x", "1m5"} {
+		if _, err := ParseInterval(input); err == nil {
+			t.Errorf("expected error parsing %q", input)
+		}
+	}
+
+	if ms := parseIntervalMs("$__auto_interval_interval"); ms != 0 {
+		t.Errorf("expected auto interval to be 0. got %v", ms)
+	}
+}
+
+func TestCalculateInterval(t *testing.T) {
+	now := time.Now()
+	tr := TimeRange{From: now.Add(-6 * time.Hour), To: now}
+
+	// 6h / 1000 points = 21.6s, rounded to 20s
+	if d := CalculateInterval(tr, 1000, 0); d != 20*time.Second {
+		t.Errorf("wanted 20s. got %v", d)
+	}
+	if d := CalculateInterval(tr, 1000, time.Minute); d != time.Minute {
+		t.Errorf("wanted min interval 1m. got %v", d)
+	}
+	if d := CalculateInterval(tr, 100, 0); d != 5*time.Minute {
+		t.Errorf("wanted 5m. got %v", d)
+	}
+}
+
+func TestGetPanelDataUsesScrapeInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		expected float64
+	}{
+		{"auto", "", 30000},
+		{"variable", "$interval", 120000},
+		{"explicit", "1h30m", 5400000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+			dashboard, err := g.GetDashboard("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			dashboard.Dashboard.Panels[0].Interval = tt.interval
+
+			var capturedBody []byte
+			g.client = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					body := `{"results":{}}`
+					if req.Method == http.MethodGet {
+						body = `{"uid":"c8f641f5-80c3-41e2-bf79-d307ae89cf8f","type":"prometheus","jsonData":{"timeInterval":"30s"}}`
+					} else {
+						capturedBody, _ = io.ReadAll(req.Body)
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(strings.NewReader(body)),
+					}, nil
+				},
+			}
+
+			now := time.Now()
+			_, err = g.getPanelData(context.Background(), 1, dashboard,
+				WithTimeRange(now.Add(-time.Hour), now), WithVariables(map[string]string{"interval": "2m"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var request struct {
+				Queries []map[string]any `json:"queries"`
+			}
+			if err := json.Unmarshal(capturedBody, &request); err != nil {
+				t.Fatal(err)
+			}
+			if ms := request.Queries[0]["intervalMs"]; ms != tt.expected {
+				t.Errorf("wanted intervalMs %v. got %v", tt.expected, ms)
+			}
+		})
+	}
+}
+
 func TestGetPanelDataInjectsMaxDataPoints(t *testing.T) {
 	// loading in the dashboard
 	client := CreateMockClient(t, "dashboard.json", http.StatusOK)
 	g := CreateMockGrafanaClient(t, client)
 
-	dashboard, err := g.getDashboard("foo")
+	dashboard, err := g.getDashboard(context.Background(), "foo")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,7 +352,7 @@ func TestGetPanelDataInjectsMaxDataPoints(t *testing.T) {
 		},
 	}
 
-	_, err = g.getPanelData(2, dashboard, WithTimeRange(time.Now(), time.Time{}))
+	_, err = g.getPanelData(context.Background(), 2, dashboard, WithTimeRange(time.Now(), time.Time{}))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -249,3 +366,2271 @@ func TestGetPanelDataInjectsMaxDataPoints(t *testing.T) {
 		t.Error("expected intervalMs to be injected into query targets")
 	}
 }
+
+func TestGetDashboardContextCanceled(t *testing.T) {
+	client := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+			t.Fatal("expected request context to be canceled")
+			return nil, nil
+		},
+	}
+
+	g := CreateMockGrafanaClient(t, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := g.GetDashboardContext(ctx, "foo")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("wanted context.Canceled. got %v", err)
+	}
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	var attempts int
+	var bodies []string
+	client := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			b, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(b))
+			if attempts == 1 {
+				return &http.Response{
+					StatusCode: http.StatusServiceUnavailable,
+					Header:     http.Header{"Retry-After": []string{"0"}},
+					Body:       io.NopCloser(strings.NewReader("unavailable")),
+				}, nil
+			}
+			if attempts == 2 {
+				return nil, errors.New("connection reset")
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("{}")),
+			}, nil
+		},
+	}
+
+	g := CreateMockGrafanaClient(t, client)
+	policy := DefaultRetryPolicy()
+	policy.MinBackoff = time.Millisecond
+	policy.MaxBackoff = time.Millisecond
+	WithRetryPolicy(policy)(g)
+
+	req, err := g.NewRequest(http.MethodPost, "http://example.com/api/ds/query", strings.NewReader("query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := g.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wanted 200. got %v", resp.StatusCode)
+	}
+	if attempts != 3 {
+		t.Fatalf("wanted 3 attempts. got %v", attempts)
+	}
+	for i, b := range bodies {
+		if b != "query" {
+			t.Errorf("attempt %v sent body %q", i+1, b)
+		}
+	}
+
+	// non idempotent requests are never retried
+	attempts = 0
+	req, _ = g.NewRequest(http.MethodPost, "http://example.com/api/dashboards/db", strings.NewReader("{}"))
+	if _, err = g.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("wanted 1 attempt. got %v", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("120", now)
+	if !ok || d != 2*time.Minute {
+		t.Errorf("wanted 2m. got %v %v", d, ok)
+	}
+
+	d, ok = parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	if !ok || d != 30*time.Second {
+		t.Errorf("wanted 30s. got %v %v", d, ok)
+	}
+
+	if _, ok = parseRetryAfter("soon", now); ok {
+		t.Error("wanted invalid header to be ignored")
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	body := `{"message":"Dashboard not found","traceID":"abc123"}`
+	client := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	}
+
+	g := CreateMockGrafanaClient(t, client)
+
+	_, err := g.GetDashboard("foo")
+	if !errors.Is(err, ErrDashboardNotFound) {
+		t.Fatalf("wanted ErrDashboardNotFound. got %v", err)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("wanted ErrNotFound. got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wanted *APIError. got %T", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("wanted status 404. got %v", apiErr.StatusCode)
+	}
+	if apiErr.Endpoint != "/api/dashboards/uid/foo" {
+		t.Errorf("wanted endpoint /api/dashboards/uid/foo. got %v", apiErr.Endpoint)
+	}
+	if apiErr.Message != "Dashboard not found" || apiErr.TraceID != "abc123" {
+		t.Errorf("unexpected message %q or traceID %q", apiErr.Message, apiErr.TraceID)
+	}
+
+	g = CreateMockGrafanaClient(t, CreateMockClient(t, "search.json", http.StatusUnauthorized))
+	_, err = g.FetchDashboards()
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("wanted ErrUnauthorized. got %v", err)
+	}
+
+	g = CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	dashboard, err := g.GetDashboard("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.getPanelData(context.Background(), 99, dashboard)
+	if !errors.Is(err, ErrPanelNotFound) {
+		t.Fatalf("wanted ErrPanelNotFound. got %v", err)
+	}
+}
+
+func TestGetPanelDataQueryErrors(t *testing.T) {
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	dashboard, err := g.GetDashboard("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"results":{"A":{"status":200,"frames":[]},"B":{"status":400,"error":"bad_data: parse error","errorSource":"downstream","frames":[]}}}`
+	g.client = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	}
+
+	data, err := g.getPanelData(context.Background(), 2, dashboard)
+	if err != nil {
+		t.Fatalf("wanted partial results without error. got %v", err)
+	}
+
+	errs := data.Errors()
+	if len(errs) != 1 || errs[0].RefID != "B" || errs[0].Source != "downstream" {
+		t.Fatalf("unexpected query errors %+v", errs)
+	}
+
+	_, err = g.getPanelData(context.Background(), 2, dashboard, WithQueryErrorsFatal())
+	var partial *PartialResultError
+	if !errors.As(err, &partial) {
+		t.Fatalf("wanted *PartialResultError. got %v", err)
+	}
+	var queryErr QueryError
+	if !errors.As(err, &queryErr) || queryErr.Message != "bad_data: parse error" {
+		t.Fatalf("wanted QueryError for B. got %v", err)
+	}
+
+	// failed queries answered with 400 or 207 keep the results of the others
+	respond := func(body string, status int) *MockHTTPClient {
+		return &MockHTTPClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}, nil
+			},
+		}
+	}
+	frames := `{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]}, "data": {"values": [[1000], [1]]}}`
+	body = `{"results":{"A":{"status":200,"frames":[` + frames + `]},"B":{"status":400,"error":"bad_data: parse error"}}}`
+	for _, status := range []int{http.StatusBadRequest, http.StatusMultiStatus} {
+		g.client = respond(body, status)
+		data, err := g.getPanelData(context.Background(), 2, dashboard)
+		if err != nil {
+			t.Fatalf("%v: wanted partial results without error. got %v", status, err)
+		}
+		if errs := data.Errors(); len(errs) != 1 || errs[0].RefID != "B" || len(data.Results["A"].Frames) != 1 {
+			t.Errorf("%v: unexpected results %+v", status, data)
+		}
+		if _, err := g.getPanelData(context.Background(), 2, dashboard, WithQueryErrorsFatal()); !errors.As(err, &partial) {
+			t.Errorf("%v: wanted *PartialResultError. got %v", status, err)
+		}
+	}
+
+	// other bad requests are API errors
+	g.client = respond(`{"message":"bad request"}`, http.StatusBadRequest)
+	var apiErr *APIError
+	if _, err := g.getPanelData(context.Background(), 2, dashboard); !errors.As(err, &apiErr) || apiErr.Message != "bad request" {
+		t.Errorf("wanted *APIError. got %v", err)
+	}
+}
+
+func TestFrameTypedColumns(t *testing.T) {
+	body := `{
+		"schema": {
+			"fields": [
+				{"name": "Time", "type": "time", "typeInfo": {"frame": "time.Time"}},
+				{"name": "Value", "type": "number", "typeInfo": {"frame": "float64", "nullable": true}},
+				{"name": "Count", "type": "number", "typeInfo": {"frame": "int64"}},
+				{"name": "Host", "type": "string", "typeInfo": {"frame": "string"}},
+				{"name": "Up", "type": "boolean", "typeInfo": {"frame": "bool"}},
+				{"name": "Meta", "type": "other", "typeInfo": {"frame": "json.RawMessage"}}
+			]
+		},
+		"data": {
+			"values": [
+				[1708050600000, 1708050660000, 1708050720000, 1708050780000],
+				[1.5, null, null, null],
+				[1, 2, 3, 9007199254740993],
+				["a", "b", "c", "d"],
+				[true, false, true, false],
+				[{"k": 1}, null, [1, 2], "x"]
+			],
+			"entities": [null, {"NaN": [1], "Inf": [2]}, null, null, null, null]
+		}
+	}`
+
+	var frame Frame
+	if err := json.Unmarshal([]byte(body), &frame); err != nil {
+		t.Fatal(err)
+	}
+
+	cols := frame.Data.Values
+	if len(cols) != 6 {
+		t.Fatalf("wanted 6 columns. got %v", len(cols))
+	}
+
+	if cols[0].Kind != KindTime || !cols[0].Times[1].Equal(time.UnixMilli(1708050660000)) {
+		t.Errorf("unexpected time column %+v", cols[0])
+	}
+
+	values := cols[1]
+	if v, ok := values.Float64(0); !ok || v != 1.5 {
+		t.Errorf("wanted 1.5. got %v %v", v, ok)
+	}
+	if v, _ := values.Float64(1); !math.IsNaN(v) {
+		t.Errorf("wanted NaN. got %v", v)
+	}
+	if v, _ := values.Float64(2); !math.IsInf(v, 1) {
+		t.Errorf("wanted +Inf. got %v", v)
+	}
+	if _, ok := values.Float64(3); ok || !values.IsNull(3) {
+		t.Error("wanted row 3 to be null")
+	}
+
+	if cols[2].Kind != KindInt64 || cols[2].Ints[3] != 9007199254740993 {
+		t.Errorf("unexpected int column %+v", cols[2])
+	}
+	if cols[3].Kind != KindString || cols[3].Strings[2] != "c" {
+		t.Errorf("unexpected string column %+v", cols[3])
+	}
+	if cols[4].Kind != KindBool || !cols[4].Bools[2] {
+		t.Errorf("unexpected bool column %+v", cols[4])
+	}
+	if cols[5].Kind != KindJSON || string(cols[5].JSON[2]) != "[1,2]" || !cols[5].IsNull(1) {
+		t.Errorf("unexpected json column %+v", cols[5])
+	}
+
+	// encoding the frame again keeps NaN and Inf as entities
+	b, err := json.Marshal(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Frame
+	if err := json.Unmarshal(b, &again); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := again.Data.Values[1].Float64(1); !math.IsNaN(v) {
+		t.Errorf("wanted NaN after round trip. got %v", v)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	ip := NewInterpolator(map[string]VariableValue{
+		"host":     {Values: []string{"web-1"}},
+		"hostname": {Values: []string{"web-1.example.com"}},
+		"pods":     {Values: []string{"a.1", "b"}, Multi: true},
+		"job":      {Values: []string{"$__all"}, IncludeAll: true, AllValue: ".*"},
+		"env":      {Values: []string{"$__all"}, IncludeAll: true, Options: []string{"prod", "dev"}},
+	})
+	ip.From = time.UnixMilli(1700000000000).UTC()
+	ip.To = ip.From.Add(6 * time.Hour)
+	ip.Interval = 30 * time.Second
+
+	tests := []struct {
+		input    string
+		dsType   string
+		expected string
+	}{
+		{"up{host=\"$host\", name=\"$hostname\"}", "", "up{host=\"web-1\", name=\"web-1.example.com\"}"},
+		{"${host}-[[host]]", "", "web-1-web-1"},
+		{"$pods", "", "{a.1,b}"},
+		{"$pods", "prometheus", `(a\\.1|b)`},
+		{"${pods:csv}", "prometheus", "a.1,b"},
+		{"${pods:pipe}", "", "a.1|b"},
+		{"${pods:regex}", "", `(a\.1|b)`},
+		{"${pods:json}", "", `["a.1","b"]`},
+		{"${pods:queryparam}", "", "var-pods=a.1&var-pods=b"},
+		{"${pods:singlequote}", "", "'a.1','b'"},
+		{"[[pods:pipe]]", "", "a.1|b"},
+		{"up{job=~\"$job\"}", "prometheus", "up{job=~\".*\"}"},
+		{"$env", "prometheus", "(prod|dev)"},
+		{"$unknown", "", "$unknown"},
+		{"rate(x[$__rate_interval]) $__interval $__range", "prometheus", "rate(x[1m]) 30s 6h"},
+		{"$__from ${__to:date:seconds} ${__from:date}", "", "1700000000000 1700021600 2023-11-14T22:13:20.000Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := ip.Interpolate(tt.input, tt.dsType)
+			if result != tt.expected {
+				t.Errorf("Interpolate(%q, %q) = %q, want %q", tt.input, tt.dsType, result, tt.expected)
+			}
+		})
+	}
+}
+
+// CreateRoutingMockClient returns canned bodies by request path
+func CreateRoutingMockClient(t *testing.T, routes map[string]string) *MockHTTPClient {
+	return &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body, ok := routes[req.URL.Path]
+			if !ok {
+				t.Errorf("unexpected request %v", req.URL.String())
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       io.NopCloser(strings.NewReader(`{"message":"not found"}`)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	}
+}
+
+func TestGetDashboardVariablesTypes(t *testing.T) {
+	dashboard := `{"dashboard": {"templating": {"list": [
+		{"name": "env", "type": "custom", "query": "Production : prod, Dev : dev, a\\,b", "includeAll": true, "allValue": ".*"},
+		{"name": "region", "type": "constant", "query": "eu-1"},
+		{"name": "step", "type": "interval", "query": "1m,5m, 1h"},
+		{"name": "filter", "type": "textbox", "query": "default", "current": {"text": "typed", "value": "typed"}},
+		{"name": "ds", "type": "datasource", "query": "prometheus", "regex": "/^Prom/"},
+		{"name": "labels", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": {"query": "label_names()"}, "regex": "/^(?!__).*/"},
+		{"name": "job", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": "label_values(job)", "sort": 2},
+		{"name": "node", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": "query_result(up{job=~\"$job\"})", "regex": "/instance=\"([^\"]+)\"/", "sort": 3},
+		{"name": "metric", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": "metrics(^go_)"},
+		{"name": "Filters", "type": "adhoc", "filters": [{"key": "job", "operator": "=", "value": "api"}]}
+	]}}}`
+
+	var response DashboardResponse
+	if err := json.Unmarshal([]byte(dashboard), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	client := CreateRoutingMockClient(t, map[string]string{
+		"/api/datasources": `[{"type":"prometheus","uid":"p1","name":"Prometheus","isDefault":true},{"type":"prometheus","uid":"p2","name":"Other"},{"type":"loki","uid":"l1","name":"Prom Loki"}]`,
+		"/api/datasources/uid/p1/resources/api/v1/labels":                `{"status":"success","data":["__name__","instance","job"]}`,
+		"/api/datasources/uid/p1/resources/api/v1/label/job/values":      `{"status":"success","data":["api","db","cache"]}`,
+		"/api/datasources/uid/p1/resources/api/v1/label/__name__/values": `{"status":"success","data":["go_goroutines","up","go_gc_duration_seconds"]}`,
+		"/api/datasources/uid/p1/resources/api/v1/query": `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"__name__":"up","instance":"node-10","job":"api"},"value":[1700000000,"1"]},
+			{"metric":{"__name__":"up","instance":"node-9","job":"db"},"value":[1700000000,"1"]}]}}`,
+	})
+	g := CreateMockGrafanaClient(t, client)
+
+	vars, err := g.GetDashboardVariables(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"env":     {"$__all", "prod", "dev", "a,b"},
+		"region":  {"eu-1"},
+		"step":    {"1m", "5m", "1h"},
+		"filter":  {"typed"},
+		"ds":      {"Prometheus"},
+		"labels":  {"instance", "job"},
+		"job":     {"db", "cache", "api"},
+		"node":    {"node-9", "node-10"},
+		"metric":  {"go_goroutines", "go_gc_duration_seconds"},
+		"Filters": {`job="api"`},
+	}
+	for name, want := range expected {
+		if got := vars[name]; strings.Join(got, ";") != strings.Join(want, ";") {
+			t.Errorf("variable %v: wanted %v. got %v", name, want, got)
+		}
+	}
+}
+
+func TestApplyVariableRegexLookaheads(t *testing.T) {
+	values := []string{"__name__", "instance", "job", "_private", "Job_total"}
+	tests := []struct {
+		regex    string
+		expected []string
+	}{
+		{`/^(?!__).*/`, []string{"instance", "job", "_private", "Job_total"}},
+		{`/^(?!__|inst).*/`, []string{"job", "_private", "Job_total"}},
+		{`/^(?=j)(?!.*total)(.*)/i`, []string{"job"}},
+		{`/(?!_)(?<value>[a-z]+)$/`, []string{"instance", "job", "private", "total"}},
+		{`/^(?![(])[^_]+$/`, []string{"instance", "job"}},
+	}
+	for _, tt := range tests {
+		got, err := applyVariableRegex(values, tt.regex)
+		if err != nil {
+			t.Errorf("%v: %v", tt.regex, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: expected %v. got %v", tt.regex, tt.expected, got)
+		}
+	}
+}
+
+func TestNamedGroups(t *testing.T) {
+	tests := map[string]string{
+		`(?<text>[a-z]+)-(?<value>\d+)`: `(?P<text>[a-z]+)-(?P<value>\d+)`,
+		`((?<value>a)(?<text>b))`:       `((?P<value>a)(?P<text>b))`,
+		`\(?<value>`:                    `\(?<value>`,
+		`(?<=a)(?<!b)(?P<value>c)`:      `(?<=a)(?<!b)(?P<value>c)`,
+	}
+	for pattern, expected := range tests {
+		if got := namedGroups(pattern); got != expected {
+			t.Errorf("%v: expected %v. got %v", pattern, expected, got)
+		}
+	}
+}
+
+func TestResolveVariablesDependencyOrder(t *testing.T) {
+	dashboard := `{"dashboard": {"templating": {"list": [
+		{"name": "disk", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": "label_values(disk_io{cluster=\"$cluster\", node=~\"$node\"}, disk)"},
+		{"name": "node", "type": "query", "datasource": {"type": "prometheus", "uid": "p1"}, "query": "label_values(up{cluster=\"$cluster\"}, node)", "multi": true,
+			"current": {"text": ["n2", "gone"], "value": ["n2", "gone"]}},
+		{"name": "cluster", "type": "custom", "query": "c1,c2", "current": {"text": "c2", "value": "c2"}}
+	]}}}`
+
+	var response DashboardResponse
+	if err := json.Unmarshal([]byte(dashboard), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var matches []string
+	client := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			matches = append(matches, req.URL.Query().Get("match[]"))
+			body := `{"status":"success","data":["n1","n2"]}`
+			if strings.HasSuffix(req.URL.Path, "/label/disk/values") {
+				body = `{"status":"success","data":["sda","sdb"]}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	}
+	g := CreateMockGrafanaClient(t, client)
+
+	set, err := g.ResolveVariables(response, WithVariables(map[string]string{"disk": "sdb"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order := strings.Join(set.Order, ","); order != "cluster,node,disk" {
+		t.Errorf("wanted order cluster,node,disk. got %v", order)
+	}
+
+	expectedMatches := []string{`up{cluster="c2"}`, `disk_io{cluster="c2", node=~"n2"}`}
+	if strings.Join(matches, ";") != strings.Join(expectedMatches, ";") {
+		t.Errorf("wanted queries %v. got %v", expectedMatches, matches)
+	}
+
+	selected := set.Selected()
+	if s := strings.Join(selected["node"], ","); s != "n2" {
+		t.Errorf("wanted node selection n2. got %v", s)
+	}
+	if s := strings.Join(selected["disk"], ","); s != "sdb" {
+		t.Errorf("wanted explicit disk selection sdb. got %v", s)
+	}
+
+	// a variable that references itself through another one is a cycle
+	response.Dashboard.Templating.List[2].Query = "$disk"
+	response.Dashboard.Templating.List[2].Type = "query"
+	_, err = g.ResolveVariables(response)
+	if !errors.Is(err, ErrVariableCycle) {
+		t.Fatalf("wanted ErrVariableCycle. got %v", err)
+	}
+}
+
+func TestGetPanelDataUsesCurrentVariableValues(t *testing.T) {
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	dashboard, err := g.GetDashboard("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templating := `[
+		{"name": "instance", "type": "query", "multi": true, "current": {"text": ["a:1", "b:2"], "value": ["a:1", "b:2"]}},
+		{"name": "job", "type": "query", "includeAll": true, "current": {"text": "All", "value": "$__all"}},
+		{"name": "env", "type": "custom", "current": {"text": "prod", "value": "prod"}}
+	]`
+	if err := json.Unmarshal([]byte(templating), &dashboard.Dashboard.Templating.List); err != nil {
+		t.Fatal(err)
+	}
+	target := dashboard.Dashboard.Panels[0].Targets[0].(map[string]any)
+	target["expr"] = `up{instance=~"$instance", job=~"$job", env="$env"}`
+
+	var capturedBody []byte
+	g.client = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPost {
+				capturedBody, _ = io.ReadAll(req.Body)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"results":{}}`)),
+			}, nil
+		},
+	}
+
+	_, err = g.getPanelData(context.Background(), 1, dashboard, WithVariables(map[string]string{"env": "dev"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request struct {
+		Queries []map[string]any `json:"queries"`
+	}
+	if err := json.Unmarshal(capturedBody, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `up{instance=~"(a:1|b:2)", job=~".*", env="dev"}`
+	if expr := request.Queries[0]["expr"]; expr != expected {
+		t.Errorf("wanted expr %v. got %v", expected, expr)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, time.May, 15, 13, 45, 30, 0, time.UTC)
+	opts := TimeOptions{Location: time.UTC, FiscalYearStartMonth: 3, WeekStart: time.Monday}
+
+	tests := []struct {
+		input    string
+		roundUp  bool
+		expected time.Time
+	}{
+		{"now", false, now},
+		{"now-6h", false, now.Add(-6 * time.Hour)},
+		{"now/d", false, time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC)},
+		{"now/d", true, time.Date(2024, time.May, 15, 23, 59, 59, int(999*time.Millisecond), time.UTC)},
+		{"now-1d/d", false, time.Date(2024, time.May, 14, 0, 0, 0, 0, time.UTC)},
+		{"now-1w/w", false, time.Date(2024, time.May, 6, 0, 0, 0, 0, time.UTC)},
+		{"now-1M", false, time.Date(2024, time.April, 15, 13, 45, 30, 0, time.UTC)},
+		{"now/fy", false, time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/fQ", true, time.Date(2024, time.June, 30, 23, 59, 59, int(999*time.Millisecond), time.UTC)},
+		{"1700000000000", false, time.UnixMilli(1700000000000)},
+		{"2024-01-02 03:04:05", false, time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseTime(tt.input, now, tt.roundUp, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Equal(tt.expected) {
+				t.Errorf("ParseTime(%q, %v) = %v, want %v", tt.input, tt.roundUp, result, tt.expected)
+			}
+		})
+	}
+
+	// month arithmetic clamps to the end of the month
+	result, _ := ParseTime("now-1M", time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC), false, opts)
+	if !result.Equal(time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wanted 2024-02-29. got %v", result)
+	}
+
+	// timezone of the dashboard is used for rounding
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err == nil {
+		result, _ = ParseTime("now/d", now, false, TimeOptions{Location: berlin})
+		if !result.Equal(time.Date(2024, time.May, 15, 0, 0, 0, 0, berlin)) {
+			t.Errorf("wanted start of day in Berlin. got %v", result)
+		}
+	}
+
+	for _, invalid := range []string{"now-", "now/2d", "now-1x", "yesterday"} {
+		if _, err := ParseTime(invalid, now, false, opts); err == nil {
+			t.Errorf("wanted error for %q", invalid)
+		}
+	}
+}
+
+func TestGetPanelDataReportsRange(t *testing.T) {
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	dashboard, err := g.GetDashboard("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dashboard.Dashboard.Time.From = "now-2d/d"
+	dashboard.Dashboard.Time.To = "now-1d/d"
+	dashboard.Dashboard.Timezone = "utc"
+
+	g.client = CreateMockClient(t, "data.json", http.StatusOK)
+	data, err := g.getPanelData(context.Background(), 2, dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := data.Range.To.Sub(data.Range.From); d != 2*24*time.Hour-time.Millisecond {
+		t.Errorf("wanted two full days. got %v", d)
+	}
+	if data.Range.From.Hour() != 0 || data.Range.From.Location() != time.UTC {
+		t.Errorf("wanted range starting at midnight utc. got %v", data.Range.From)
+	}
+}
+
+func TestPanelTimeOverrides(t *testing.T) {
+	now := time.Date(2024, time.May, 15, 13, 0, 0, 0, time.UTC)
+	opts := TimeOptions{Location: time.UTC}
+	dashboardRange := TimeRange{From: now.Add(-6 * time.Hour), To: now}
+	ip := NewInterpolator(map[string]VariableValue{"shift": {Values: []string{"1w"}}})
+
+	panel := Panel{TimeFrom: "1h", TimeShift: "$shift"}
+	tr, info, err := applyPanelTimeOverrides(panel, dashboardRange, true, now, opts, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	week := 7 * 24 * time.Hour
+	if !tr.From.Equal(now.Add(-time.Hour-week)) || !tr.To.Equal(now.Add(-week)) {
+		t.Errorf("unexpected range %v - %v", tr.From, tr.To)
+	}
+	if info != "Last 1 hour timeshift -1w" {
+		t.Errorf("unexpected info %q", info)
+	}
+
+	// relative time does not replace absolute ranges, the shift still applies
+	tr, _, err = applyPanelTimeOverrides(panel, dashboardRange, false, now, opts, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.From.Equal(dashboardRange.From.Add(-week)) {
+		t.Errorf("unexpected from %v", tr.From)
+	}
+
+	panel.HideTimeOverride = true
+	if _, info, _ = applyPanelTimeOverrides(panel, dashboardRange, true, now, opts, ip); info != "" {
+		t.Errorf("wanted hidden override info. got %q", info)
+	}
+
+	// the override is applied by getPanelData unless disabled
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	dashboard, err := g.GetDashboard("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard.Dashboard.Panels[1].TimeFrom = "30m"
+
+	g.client = CreateMockClient(t, "data.json", http.StatusOK)
+	data, err := g.getPanelData(context.Background(), 2, dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := data.Range.To.Sub(data.Range.From); d != 30*time.Minute {
+		t.Errorf("wanted 30m range. got %v", d)
+	}
+
+	g.client = CreateMockClient(t, "data.json", http.StatusOK)
+	data, err = g.getPanelData(context.Background(), 2, dashboard, WithoutPanelTimeOverrides())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := data.Range.To.Sub(data.Range.From); d != 6*time.Hour {
+		t.Errorf("wanted dashboard 6h range. got %v", d)
+	}
+}
+
+func TestWalkNestedPanels(t *testing.T) {
+	var dashboard DashboardResponse
+	err := json.Unmarshal([]byte(`{"dashboard": {"panels": [
+		{"id": 1, "type": "timeseries", "title": "top"},
+		{"id": 2, "type": "row", "title": "Row A", "collapsed": true, "panels": [
+			{"id": 3, "type": "timeseries", "title": "inside"},
+			{"id": 4, "type": "group", "title": "Group", "panels": [
+				{"id": 5, "type": "stat", "title": "deep"}
+			]}
+		]},
+		{"id": 6, "type": "row", "title": "Row B", "panels": []}
+	]}}`), &dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := dashboard.GetPanelByID(5); p == nil || p.Title != "deep" {
+		t.Errorf("expected to find nested panel 5. got %v", p)
+	}
+	if p := dashboard.GetPanelByTitle("inside"); p == nil || p.ID != 3 {
+		t.Errorf("expected to find panel inside collapsed row. got %v", p)
+	}
+	if p := dashboard.GetPanelByID(42); p != nil {
+		t.Errorf("expected no panel. got %v", p)
+	}
+
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	panels := g.FetchPanelsFromDashboard(dashboard)
+	if len(panels) != 6 {
+		t.Fatalf("expected 6 panels. got %v", len(panels))
+	}
+	deep := panels[4]
+	if deep.ID != 5 || strings.Join(deep.Path, "/") != "Row A/Group" {
+		t.Errorf("wanted panel 5 at Row A/Group. got %v at %v", deep.ID, deep.Path)
+	}
+	if len(panels[0].Path) != 0 {
+		t.Errorf("expected top level panel to have no path. got %v", panels[0].Path)
+	}
+}
+
+const repeatDashboard = `{"dashboard": {
+	"templating": {"list": [
+		{"name": "instance", "type": "custom", "multi": true, "current": {"text": ["a:1", "b:2", "c:3"], "value": ["a:1", "b:2", "c:3"]}},
+		{"name": "host", "type": "custom", "includeAll": true, "current": {"text": "All", "value": "$__all"},
+		 "options": [{"text": "All", "value": "$__all"}, {"text": "h1", "value": "h1"}, {"text": "h2", "value": "h2"}]}
+	]},
+	"panels": [
+		{"id": 1, "type": "timeseries", "title": "CPU $instance", "repeat": "instance", "repeatDirection": "h", "maxPerRow": 2,
+		 "gridPos": {"h": 8, "w": 12, "x": 0, "y": 0},
+		 "datasource": {"type": "prometheus", "uid": "p1"},
+		 "targets": [{"refId": "A", "expr": "up{instance=\"$instance\"}"}]},
+		{"id": 2, "type": "row", "title": "Host $host", "repeat": "host", "gridPos": {"h": 1, "w": 24, "x": 0, "y": 16}},
+		{"id": 3, "type": "stat", "title": "Load", "gridPos": {"h": 4, "w": 6, "x": 0, "y": 17},
+		 "datasource": {"type": "prometheus", "uid": "p1"},
+		 "targets": [{"refId": "A", "expr": "load{host=\"$host\"}"}]}
+	]
+}}`
+
+func TestExpandRepeats(t *testing.T) {
+	var dashboard DashboardResponse
+	if err := json.Unmarshal([]byte(repeatDashboard), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusOK))
+	panels, err := g.ExpandRepeats(dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(panels) != 5 {
+		t.Fatalf("expected 5 panels. got %v", len(panels))
+	}
+
+	expected := []struct {
+		id    int
+		title string
+		path  string
+		pos   GridPos
+	}{
+		{1, "CPU a:1", "", GridPos{H: 8, W: 12, X: 0, Y: 0}},
+		{1, "CPU b:2", "", GridPos{H: 8, W: 12, X: 12, Y: 0}},
+		{1, "CPU c:3", "", GridPos{H: 8, W: 12, X: 0, Y: 8}},
+		{3, "Load", "Host h1", GridPos{H: 4, W: 6, X: 0, Y: 17}},
+		{3, "Load", "Host h2", GridPos{H: 4, W: 6, X: 0, Y: 22}},
+	}
+	for i, e := range expected {
+		p := panels[i]
+		if p.Panel.ID != e.id || p.Panel.Title != e.title || strings.Join(p.Path, "/") != e.path || p.Panel.GridPos != e.pos {
+			t.Errorf("panel %v: wanted %v %q at %q %+v. got %v %q at %q %+v", i,
+				e.id, e.title, e.path, e.pos, p.Panel.ID, p.Panel.Title, strings.Join(p.Path, "/"), p.Panel.GridPos)
+		}
+	}
+	if panels[4].ScopedVars["host"] != "h2" {
+		t.Errorf("wanted scoped host h2. got %v", panels[4].ScopedVars)
+	}
+
+	// explicit values replace the saved selection
+	panels, err = g.ExpandRepeats(dashboard, WithMultiValueVariables(map[string][]string{"instance": {"x:9"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(panels) != 3 || panels[0].ScopedVars["instance"] != "x:9" {
+		t.Errorf("expected a single copy for x:9. got %+v", panels)
+	}
+
+	// five copies share the row like in Grafana, 4.8 columns each, rounded down
+	five := strings.Replace(repeatDashboard, `"maxPerRow": 2`, `"maxPerRow": 6`, 1)
+	if err := json.Unmarshal([]byte(five), &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	panels, err = g.ExpandRepeats(dashboard, WithMultiValueVariables(map[string][]string{"instance": {"a", "b", "c", "d", "e"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xs []int
+	for _, p := range panels[:5] {
+		if p.Panel.GridPos.W != 4 || p.Panel.GridPos.Y != 0 {
+			t.Errorf("unexpected grid position %+v", p.Panel.GridPos)
+		}
+		xs = append(xs, p.Panel.GridPos.X)
+	}
+	if expected := []int{0, 4, 9, 14, 19}; !reflect.DeepEqual(xs, expected) {
+		t.Errorf("wanted x positions %v. got %v", expected, xs)
+	}
+}
+
+func TestGetRepeatedPanelData(t *testing.T) {
+	// the library panel is not resolved, only the requested panel is expanded
+	dashboard := strings.Replace(repeatDashboard, `"panels": [`, `"panels": [
+		{"id": 9, "title": "Shared", "gridPos": {"h": 8, "w": 12, "x": 0, "y": 24}, "libraryPanel": {"uid": "lib1", "name": "Shared"}},`, 1)
+
+	var exprs []string
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := dashboard
+			if req.Method == http.MethodGet && req.URL.Path != "/api/dashboards/uid/foo" {
+				t.Errorf("unexpected request %v", req.URL.Path)
+			}
+			if req.Method == http.MethodPost {
+				var request struct {
+					Queries []map[string]any `json:"queries"`
+				}
+				b, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(b, &request); err != nil {
+					t.Fatal(err)
+				}
+				exprs = append(exprs, request.Queries[0]["expr"].(string))
+				body = `{"results":{}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+	g.cacheDatasources(Datasource{UID: "p1", Type: "prometheus"})
+
+	results, err := g.GetRepeatedPanelData("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results. got %v", len(results))
+	}
+
+	expected := []string{`up{instance="a:1"}`, `up{instance="b:2"}`, `up{instance="c:3"}`}
+	if strings.Join(exprs, ",") != strings.Join(expected, ",") {
+		t.Errorf("wanted queries %v. got %v", expected, exprs)
+	}
+
+	if _, err := g.GetRepeatedPanelData("foo", 42); !errors.Is(err, ErrPanelNotFound) {
+		t.Errorf("expected ErrPanelNotFound. got %v", err)
+	}
+}
+
+func TestLibraryPanels(t *testing.T) {
+	libraryPanel := `{"result": {"uid": "lib1", "name": "Shared CPU", "kind": 1, "type": "timeseries", "model": {
+		"id": 99, "type": "timeseries", "title": "Shared CPU",
+		"datasource": {"type": "prometheus", "uid": "p1"},
+		"targets": [{"refId": "A", "expr": "rate(cpu[5m])"}]
+	}}}`
+
+	requests := map[string]int{}
+	var capturedBody []byte
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			requests[req.URL.Path]++
+			var body string
+			switch req.URL.Path {
+			case "/api/dashboards/uid/foo":
+				body = `{"dashboard": {"panels": [
+					{"id": 7, "title": "Shared CPU", "gridPos": {"h": 8, "w": 12, "x": 0, "y": 0},
+					 "libraryPanel": {"uid": "lib1", "name": "Shared CPU"}}
+				]}}`
+			case "/api/library-elements/lib1":
+				body = libraryPanel
+			case "/api/library-elements":
+				if req.URL.Query().Get("searchString") != "cpu" || req.URL.Query().Get("kind") != "1" {
+					t.Errorf("unexpected search %v", req.URL.RawQuery)
+				}
+				body = `{"result": {"totalCount": 1, "elements": [` + strings.TrimSuffix(strings.TrimPrefix(libraryPanel, `{"result": `), "}") + `]}}`
+			case "/api/ds/query":
+				capturedBody, _ = io.ReadAll(req.Body)
+				body = `{"results":{}}`
+			default:
+				t.Errorf("unexpected request %v", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+	g.cacheDatasources(Datasource{UID: "p1", Type: "prometheus"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.GetPanelDataFromID("foo", 7); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(string(capturedBody), "rate(cpu[5m])") {
+		t.Errorf("expected the library panel targets to be queried. got %s", capturedBody)
+	}
+	if requests["/api/library-elements/lib1"] != 1 {
+		t.Errorf("expected the library panel to be fetched once. got %v", requests["/api/library-elements/lib1"])
+	}
+
+	// callers get copies of the cached model
+	element, err := g.GetLibraryPanel("lib1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	element.Model.Targets[0].(map[string]any)["expr"] = "changed"
+	if element, _ = g.GetLibraryPanel("lib1"); element.Model.Targets[0].(map[string]any)["expr"] != "rate(cpu[5m])" {
+		t.Errorf("expected the cached model to be unchanged. got %v", element.Model.Targets)
+	}
+
+	// expired library panels are fetched again
+	g.libraryTTL = time.Minute
+	g.mu.Lock()
+	cached := g.libraryElements["lib1"]
+	cached.fetched = cached.fetched.Add(-2 * time.Minute)
+	g.libraryElements["lib1"] = cached
+	g.mu.Unlock()
+	if _, err := g.GetLibraryPanel("lib1"); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/api/library-elements/lib1"] != 2 {
+		t.Errorf("expected the expired library panel to be fetched again. got %v", requests["/api/library-elements/lib1"])
+	}
+
+	elements, err := g.SearchLibraryPanels("cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 1 || elements[0].UID != "lib1" || len(elements[0].Model.Targets) != 1 {
+		t.Errorf("unexpected library panels %+v", elements)
+	}
+}
+
+func TestLibraryPanelNotFound(t *testing.T) {
+	g := CreateMockGrafanaClient(t, CreateMockClient(t, "dashboard.json", http.StatusNotFound))
+	_, err := g.GetLibraryPanel("missing")
+	if !errors.Is(err, ErrLibraryPanelNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrLibraryPanelNotFound. got %v", err)
+	}
+}
+
+func TestLegacyDatasourceRefs(t *testing.T) {
+	var panels []Panel
+	err := json.Unmarshal([]byte(`[
+		{"id": 1, "datasource": "Prometheus"},
+		{"id": 2, "datasource": "${DS_PROMETHEUS}"},
+		{"id": 3, "datasource": "-- Mixed --"},
+		{"id": 4, "datasource": "-- Grafana --"},
+		{"id": 5, "datasource": null},
+		{"id": 6, "datasource": {"type": "prometheus", "uid": "p1"}}
+	]`), &panels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Datasource{
+		{Name: "Prometheus"},
+		{UID: "${DS_PROMETHEUS}"},
+		{Type: "datasource", UID: MixedDatasourceUID},
+		{Type: "datasource", UID: GrafanaDatasourceUID},
+		{},
+		{Type: "prometheus", UID: "p1"},
+	}
+	for i, e := range expected {
+		ds := panels[i].Datasource
+		if ds.Type != e.Type || ds.UID != e.UID || ds.Name != e.Name {
+			t.Errorf("panel %v: wanted %+v. got %+v", panels[i].ID, e, ds)
+		}
+	}
+	if !panels[2].Datasource.IsMixed() {
+		t.Error("expected mixed datasource")
+	}
+}
+
+func TestGetPanelDataResolvesDatasources(t *testing.T) {
+	dashboard := `{"dashboard": {
+		"templating": {"list": [
+			{"name": "DS", "type": "datasource", "query": "prometheus", "current": {"text": "Prometheus", "value": "Prometheus"}}
+		]},
+		"panels": [
+			{"id": 1, "datasource": "${DS}", "targets": [{"refId": "A", "expr": "up"}]},
+			{"id": 2, "datasource": "-- Mixed --", "targets": [{"refId": "A", "datasource": "Prometheus", "expr": "down"}]},
+			{"id": 3, "datasource": "-- Dashboard --", "targets": [{"refId": "A", "panelId": 1}]}
+		]
+	}}`
+
+	var queries []map[string]any
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			status, body := http.StatusOK, ""
+			switch req.URL.Path {
+			case "/api/dashboards/uid/foo":
+				body = dashboard
+			case "/api/datasources/uid/Prometheus":
+				status, body = http.StatusNotFound, `{"message":"Data source not found"}`
+			case "/api/datasources/name/Prometheus":
+				body = `{"uid": "p1", "type": "prometheus", "name": "Prometheus", "url": "http://prometheus:9090", "access": "proxy", "jsonData": {"timeInterval": "30s"}}`
+			case "/api/datasources/uid/p1":
+				body = `{"uid": "p1", "type": "prometheus", "name": "Prometheus"}`
+			case "/api/ds/query":
+				var request struct {
+					Queries []map[string]any `json:"queries"`
+				}
+				b, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(b, &request); err != nil {
+					t.Fatal(err)
+				}
+				queries = append(queries, request.Queries[0])
+				body = `{"results":{}}`
+			default:
+				t.Errorf("unexpected request %v", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+
+	for _, id := range []int{1, 2, 3} {
+		if _, err := g.GetPanelDataFromID("foo", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"up", "down", "up"}
+	if len(queries) != len(expected) {
+		t.Fatalf("expected %v queries. got %v", len(expected), len(queries))
+	}
+	for i, q := range queries {
+		// only the reference of the datasource is sent, not its configuration
+		ds, _ := q["datasource"].(map[string]any)
+		if q["expr"] != expected[i] || ds["uid"] != "p1" || ds["type"] != "prometheus" || len(ds) != 2 {
+			t.Errorf("query %v: wanted %v on p1. got %v on %v", i, expected[i], q["expr"], q["datasource"])
+		}
+	}
+}
+
+func TestDatasourceCatalog(t *testing.T) {
+	requests := map[string]int{}
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			requests[req.URL.Path]++
+			status, body := http.StatusOK, ""
+			switch req.URL.Path {
+			case "/api/datasources":
+				body = `[
+					{"id": 1, "uid": "p1", "name": "Prometheus", "type": "prometheus", "url": "http://prometheus:9090", "isDefault": true, "jsonData": {"timeInterval": "30s"}},
+					{"id": 2, "uid": "l1", "name": "Loki", "type": "loki", "url": "http://loki:3100"}
+				]`
+			case "/api/datasources/uid/p1/health":
+				body = `{"status": "OK", "message": "Successfully queried the Prometheus API."}`
+			case "/api/datasources/uid/l1/health":
+				status, body = http.StatusBadRequest, `{"status": "ERROR", "message": "Unable to connect with Loki."}`
+			case "/api/datasources/uid/gone/health", "/api/datasources/name/Missing":
+				status, body = http.StatusNotFound, `{"message": "Data source not found"}`
+			default:
+				t.Errorf("unexpected request %v", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+
+	datasources, err := g.ListDatasources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datasources) != 2 || datasources[0].URL != "http://prometheus:9090" || datasources[0].JSONData["timeInterval"] != "30s" {
+		t.Errorf("unexpected datasources %+v", datasources)
+	}
+
+	// lookups are answered from the cached list
+	if ds, err := g.GetDatasourceByUID("l1"); err != nil || ds.Name != "Loki" {
+		t.Errorf("wanted Loki. got %+v, %v", ds, err)
+	}
+	if ds, err := g.GetDatasourceByName("Prometheus"); err != nil || ds.UID != "p1" {
+		t.Errorf("wanted p1. got %+v, %v", ds, err)
+	}
+	if _, err := g.ListDatasources(); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/api/datasources"] != 1 {
+		t.Errorf("expected datasources to be listed once. got %v", requests["/api/datasources"])
+	}
+
+	// callers get copies of the cached datasources
+	datasources[0].JSONData["timeInterval"] = "1h"
+	if listed, _ := g.ListDatasources(); listed[0].JSONData["timeInterval"] != "30s" {
+		t.Errorf("expected the cached list to be unchanged. got %v", listed[0].JSONData)
+	}
+	ds, _ := g.GetDatasourceByUID("p1")
+	ds.JSONData["timeInterval"] = "1h"
+	if ds, _ := g.GetDatasourceByName("Prometheus"); ds.JSONData["timeInterval"] != "30s" {
+		t.Errorf("expected the cached datasource to be unchanged. got %v", ds.JSONData)
+	}
+	if _, err := g.GetDatasourceByName("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound. got %v", err)
+	}
+
+	// a negative TTL disables caching
+	g.datasourceTTL = -1
+	if _, err := g.ListDatasources(); err != nil {
+		t.Fatal(err)
+	}
+	if requests["/api/datasources"] != 2 {
+		t.Errorf("expected datasources to be listed again. got %v", requests["/api/datasources"])
+	}
+	g.datasourceTTL = 0
+
+	if health, err := g.CheckDatasourceHealth("p1"); err != nil || !health.OK() {
+		t.Errorf("expected healthy datasource. got %+v, %v", health, err)
+	}
+	if health, err := g.CheckDatasourceHealth("l1"); err != nil || health.OK() || health.Message != "Unable to connect with Loki." {
+		t.Errorf("expected unhealthy datasource. got %+v, %v", health, err)
+	}
+	if _, err := g.CheckDatasourceHealth("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound. got %v", err)
+	}
+
+	var dashboard DashboardResponse
+	err = json.Unmarshal([]byte(`{"dashboard": {"panels": [
+		{"id": 1, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [{"refId": "A", "datasource": "Loki"}]},
+		{"id": 2, "datasource": "Missing"},
+		{"id": 3, "datasource": "-- Mixed --", "targets": [{"refId": "A"}]}
+	]}}`), &dashboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasources, err = g.DashboardDatasources(dashboard)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the missing datasource to be reported. got %v", err)
+	}
+	if len(datasources) != 2 || datasources[0].UID != "p1" || datasources[1].UID != "l1" {
+		t.Errorf("unexpected dashboard datasources %+v", datasources)
+	}
+}
+
+func TestGetPanelDataSkipsHiddenTargets(t *testing.T) {
+	dashboard := `{"dashboard": {
+		"templating": {"list": [{"name": "min", "type": "custom", "query": "2m", "current": {"text": "2m", "value": "2m"}}]},
+		"panels": [
+			{"id": 1, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [
+				{"refId": "A", "expr": "up", "interval": "$min", "instant": true, "format": "table"},
+				{"refId": "B", "expr": "down", "hide": true}
+			]},
+			{"id": 2, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [
+				{"refId": "A", "expr": "up", "hide": true}
+			]},
+			{"id": 3, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [
+				"up",
+				{"refId": "A", "expr": "up"},
+				{"refId": "B", "expr": "up", "intervalFactor": 2}
+			]}
+		]
+	}}`
+
+	var queries [][]map[string]any
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := dashboard
+			if req.Method == http.MethodPost {
+				var request struct {
+					Queries []map[string]any `json:"queries"`
+				}
+				b, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(b, &request); err != nil {
+					t.Fatal(err)
+				}
+				queries = append(queries, request.Queries)
+				body = `{"results":{}}`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+	g.cacheDatasources(Datasource{UID: "p1", Type: "prometheus"})
+
+	result, err := g.GetPanelDataFromID("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || len(queries[0]) != 1 || queries[0][0]["refId"] != "A" {
+		t.Fatalf("expected only target A to be queried. got %v", queries)
+	}
+	if queries[0][0]["interval"] != "2m" || queries[0][0]["instant"] != true {
+		t.Errorf("expected target options to be sent. got %v", queries[0][0])
+	}
+	if target := result.Targets["A"]; !target.Instant || target.Format != FormatTable {
+		t.Errorf("unexpected target options %+v", target)
+	}
+
+	if _, err := g.GetPanelDataFromID("foo", 1, WithHiddenTargets()); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || len(queries[1]) != 2 {
+		t.Errorf("expected hidden targets to be queried. got %v", queries)
+	}
+
+	if _, err := g.GetPanelDataFromID("foo", 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Errorf("expected a panel without visible targets not to be queried")
+	}
+
+	// targets that are not objects are skipped
+	if _, err := g.GetPanelDataFromID("foo", 3); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 || len(queries[2]) != 2 {
+		t.Fatalf("expected targets A and B to be queried. got %v", queries)
+	}
+	a, b := queries[2][0]["intervalMs"].(float64), queries[2][1]["intervalMs"].(float64)
+	if a == 0 || b != 2*a {
+		t.Errorf("expected intervalFactor to double the interval. got %v and %v", a, b)
+	}
+}
+
+func TestConvertTargetFormats(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {
+		"A": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "instance", "type": "string"}, {"name": "Value", "type": "number"}]},
+			"data": {"values": [[1000, 1000, 2000], ["a", "b", "a"], [1, 2, 3]]}
+		}]},
+		"B": {"frames": [
+			{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"le": "+Inf"}}]},
+			 "data": {"values": [[1000], [10]]}},
+			{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"le": "1"}}]},
+			 "data": {"values": [[1000], [4]]}}
+		]}
+	}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Targets = map[string]TargetOptions{
+		"A": {RefID: "A", Format: FormatTable},
+		"B": {RefID: "B", Format: FormatHeatmap},
+	}
+
+	prom := ConvertResultToPrometheusFormat(results)
+	if len(prom.Data.Result) != 4 {
+		t.Fatalf("expected 4 series. got %v", len(prom.Data.Result))
+	}
+
+	a := prom.Data.Result[0]
+	if a.Metric["instance"] != "a" || len(a.Values) != 2 || a.Values[1][1] != 3.0 {
+		t.Errorf("unexpected table series %+v", a)
+	}
+	bucket, inf := prom.Data.Result[2], prom.Data.Result[3]
+	if bucket.Metric["le"] != "1" || bucket.Values[0][1] != 4.0 || inf.Metric["le"] != "+Inf" || inf.Values[0][1] != 6.0 {
+		t.Errorf("unexpected heatmap buckets %+v %+v", bucket, inf)
+	}
+}
+
+func TestTableFormatDisplayNames(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {"A": {"frames": [{
+		"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "host", "type": "string"}, {"name": "cpu", "type": "number"}]},
+		"data": {"values": [[1000, 1000, 2000], ["a", "b", "a"], [1, 2, 3]]}
+	}]}}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Targets = map[string]TargetOptions{"A": {RefID: "A", Format: FormatTable}}
+	err = json.Unmarshal([]byte(`{
+		"defaults": {"displayName": "${__field.labels.host} cpu"},
+		"overrides": [{"matcher": {"id": "byName", "options": "cpu {host=\"b\"}"}, "properties": [{"id": "displayName", "value": "Host B"}]}]
+	}`), &results.FieldConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a cpu", "Host B"}
+	var legends []string
+	for _, s := range ConvertResultToPrometheusFormat(results).Data.Result {
+		legends = append(legends, s.Metric["__legend__"])
+	}
+	if !reflect.DeepEqual(legends, expected) {
+		t.Errorf("prometheus: expected %v. got %v", expected, legends)
+	}
+
+	var columns []string
+	wide := results.WideTable()
+	for _, column := range wide.Columns[1:] {
+		columns = append(columns, column.Name)
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("wide: expected %v. got %v", expected, columns)
+	}
+	if len(wide.Rows) != 2 || wide.Rows[0][1] != 1.0 || wide.Rows[0][2] != 2.0 || wide.Rows[1][1] != 3.0 || wide.Rows[1][2] != nil {
+		t.Errorf("unexpected rows %v", wide.Rows)
+	}
+}
+
+func TestGetPanelDataExpressions(t *testing.T) {
+	dashboard := `{"dashboard": {"panels": [
+		{"id": 1, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [
+			{"refId": "A", "expr": "up", "hide": true},
+			{"refId": "B", "expr": "down", "legendFormat": "{{instance}}"},
+			{"refId": "C", "datasource": {"name": "Expression", "uid": "-100"}, "type": "math", "expression": "$A + ${B}"},
+			{"refId": "D", "type": "reduce", "expression": "A", "reducer": "mean", "hide": true}
+		]},
+		{"id": 2, "datasource": {"type": "prometheus", "uid": "p1"}, "targets": [
+			{"refId": "A", "expr": "up", "hide": true},
+			{"refId": "B", "datasource": {"type": "__expr__", "uid": "__expr__"}, "type": "sql", "expression": "SELECT * FROM A"}
+		]}
+	]}}`
+	response := `{"results": {
+		"A": {"frames": [{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]}, "data": {"values": [[1000], [1]]}}]},
+		"B": {"frames": [{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]}, "data": {"values": [[1000], [2]]}}]},
+		"C": {"frames": [{"schema": {"fields": [{"name": "C", "type": "number", "labels": {"instance": "a"}}]}, "data": {"values": [[3]]}}]}
+	}}`
+
+	var queries []map[string]any
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := dashboard
+			if req.Method == http.MethodPost {
+				var request struct {
+					Queries []map[string]any `json:"queries"`
+				}
+				b, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(b, &request); err != nil {
+					t.Fatal(err)
+				}
+				queries = request.Queries
+				body = response
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+	g.cacheDatasources(Datasource{UID: "p1", Type: "prometheus"})
+
+	result, err := g.GetPanelDataFromID("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []string
+	for _, q := range queries {
+		refs = append(refs, q["refId"].(string))
+	}
+	if strings.Join(refs, ",") != "A,B,C" {
+		t.Errorf("expected the hidden input A to be sent with B and C. got %v", refs)
+	}
+	if ds := queries[2]["datasource"].(map[string]any); ds["uid"] != ExpressionDatasourceUID || ds["type"] != ExpressionDatasourceUID {
+		t.Errorf("expected the expression datasource. got %v", ds)
+	}
+	if _, ok := result.Results["A"]; ok {
+		t.Error("expected the results of the hidden input to be dropped")
+	}
+	if target := result.Targets["C"]; !target.IsExpression() || strings.Join(target.DependsOn, ",") != "A,B" {
+		t.Errorf("unexpected expression target %+v", target)
+	}
+
+	prom := ConvertResultToPrometheusFormat(result)
+	if len(prom.Data.Result) != 2 {
+		t.Fatalf("expected 2 series. got %+v", prom.Data.Result)
+	}
+	b, c := prom.Data.Result[0], prom.Data.Result[1]
+	if b.Metric["__legend__"] != "a" {
+		t.Errorf("wanted legend a. got %v", b.Metric["__legend__"])
+	}
+	if c.Metric["__legend__"] != `C {instance="a"}` || len(c.Values) != 1 || c.Values[0][1] != 3.0 {
+		t.Errorf("unexpected expression series %+v", c)
+	}
+	if ts := c.Values[0][0]; ts != float64(result.Range.To.UnixMilli())/1000 {
+		t.Errorf("expected the reduced value at the end of the range. got %v", ts)
+	}
+
+	// the tables of SQL expressions are not parsed, so every other target is sent
+	result, err = g.GetPanelDataFromID("foo", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs = nil
+	for _, q := range queries {
+		refs = append(refs, q["refId"].(string))
+	}
+	if strings.Join(refs, ",") != "A,B" {
+		t.Errorf("expected the hidden input A to be sent with the SQL expression B. got %v", refs)
+	}
+	if target := result.Targets["B"]; strings.Join(target.DependsOn, ",") != "A" {
+		t.Errorf("expected the SQL expression to depend on A. got %+v", target)
+	}
+}
+
+func TestConvertResultTypes(t *testing.T) {
+	convert := func(t *testing.T, response string, targets map[string]TargetOptions) PrometheusMetricResponse {
+		var results Results
+		if err := json.Unmarshal([]byte(response), &results); err != nil {
+			t.Fatal(err)
+		}
+		results.Targets = targets
+		results.Range.To = time.UnixMilli(5000)
+		return ConvertResultToPrometheusFormat(results)
+	}
+
+	t.Run("vector from prometheus metadata", func(t *testing.T) {
+		prom := convert(t, `{"results": {"A": {"frames": [{
+			"schema": {"meta": {"type": "numeric-multi", "custom": {"resultType": "vector"}},
+				"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]},
+			"data": {"values": [[4000], [7]]}
+		}]}}}`, nil)
+		if prom.Data.ResultType != "vector" {
+			t.Fatalf("expected vector. got %v", prom.Data.ResultType)
+		}
+		r := prom.Data.Result[0]
+		if r.Values != nil || len(r.Value) != 2 || r.Value[0] != 4.0 || r.Value[1] != 7.0 {
+			t.Errorf("unexpected sample %+v", r)
+		}
+	})
+
+	t.Run("vector from instant target", func(t *testing.T) {
+		prom := convert(t, `{"results": {"A": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]},
+			"data": {"values": [[1000, 2000], [1, 2]]}
+		}]}}}`, map[string]TargetOptions{"A": {RefID: "A", Instant: true, Format: FormatTimeSeries}})
+		if prom.Data.ResultType != "vector" || prom.Data.Result[0].Value[1] != 2.0 {
+			t.Errorf("unexpected result %+v", prom.Data)
+		}
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		prom := convert(t, `{"results": {"A": {"frames": [{
+			"schema": {"fields": [{"name": "A", "type": "number"}]},
+			"data": {"values": [[42]]}
+		}]}}}`, nil)
+		if prom.Data.ResultType != "scalar" {
+			t.Fatalf("expected scalar. got %v", prom.Data.ResultType)
+		}
+
+		b, err := json.Marshal(prom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `"data":{"resultType":"scalar","result":[5,42]}`) {
+			t.Errorf("unexpected scalar encoding %s", b)
+		}
+
+		var decoded PrometheusMetricResponse
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Data.Result) != 1 || decoded.Data.Result[0].Value[1] != 42.0 {
+			t.Errorf("unexpected decoded scalar %+v", decoded.Data)
+		}
+	})
+
+	t.Run("matrix wins over instant results", func(t *testing.T) {
+		prom := convert(t, `{"results": {
+			"A": {"frames": [{"schema": {"meta": {"custom": {"resultType": "matrix"}}, "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]}, "data": {"values": [[1000, 2000], [1, 2]]}}]},
+			"B": {"frames": [{"schema": {"meta": {"custom": {"resultType": "vector"}}, "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]}, "data": {"values": [[2000], [3]]}}]}
+		}}`, nil)
+		if prom.Data.ResultType != "matrix" || len(prom.Data.Result[0].Values) != 2 || len(prom.Data.Result[1].Values) != 1 {
+			t.Errorf("unexpected result %+v", prom.Data)
+		}
+	})
+}
+
+func TestFieldDisplayNames(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {
+		"A": {"frames": [
+			{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a", "job": "node"}}]}, "data": {"values": [[1000], [1]]}},
+			{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "b", "job": "node"}}]}, "data": {"values": [[1000], [2]]}}
+		]},
+		"B": {"frames": [{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]}, "data": {"values": [[1000], [3]]}}]},
+		"C": {"frames": [{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}, "config": {"displayNameFromDS": "from ds"}}]}, "data": {"values": [[1000], [4]]}}]},
+		"D": {"frames": [{"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "c", "job": "node"}}]}, "data": {"values": [[1000], [5]]}}]}
+	}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Targets = map[string]TargetOptions{
+		"A": {RefID: "A", Format: FormatTimeSeries},
+		"B": {RefID: "B", Format: FormatTimeSeries, Expr: "sum(up)"},
+		"C": {RefID: "C", Format: FormatTimeSeries},
+		"D": {RefID: "D", Format: FormatTimeSeries},
+	}
+	results.Legends = map[string]string{"A": "{{ instance }} / {{job}}{{missing}}", "B": "__auto", "D": "__auto"}
+
+	names := func() map[string]string {
+		got := map[string]string{}
+		for _, s := range ConvertResultToPrometheusFormat(results).Data.Result {
+			got[s.Metric["__refId__"]+s.Metric["instance"]] = s.Metric["__legend__"]
+		}
+		return got
+	}
+
+	want := map[string]string{
+		"Aa": "a / node",
+		"Ab": "b / node",
+		"B":  "sum(up)",
+		"Ca": "from ds",
+		"Dc": `{instance="c", job="node"}`,
+	}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected legends %v", got)
+	}
+
+	err = json.Unmarshal([]byte(`{
+		"defaults": {"displayName": "${__field.labels.instance} (${__field.name})"},
+		"overrides": [
+			{"matcher": {"id": "byFrameRefID", "options": "B"}, "properties": [{"id": "displayName", "value": "total"}]},
+			{"matcher": {"id": "byName", "options": "from ds"}, "properties": [{"id": "displayName", "value": "renamed"}]}
+		]
+	}`), &results.FieldConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		"Aa": "a (Value)",
+		"Ab": "b (Value)",
+		"B":  "total",
+		"Ca": "renamed",
+		"Dc": "c (Value)",
+	}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected display names %v", got)
+	}
+}
+
+func TestFormatUnit(t *testing.T) {
+	two := 2
+	cases := []struct {
+		unit     string
+		value    float64
+		decimals *int
+		want     string
+	}{
+		{"percentunit", 0.87, nil, "87%"},
+		{"bytes", 1536, nil, "1.50 KiB"},
+		{"decbytes", 1000, nil, "1 kB"},
+		{"kbytes", 2048, nil, "2 MiB"},
+		{"ms", 1500, nil, "1.50 s"},
+		{"s", 0.5, nil, "500 ms"},
+		{"short", 1234567, nil, "1.23 Mil"},
+		{"reqps", 1200, nil, "1.20K req/s"},
+		{"currencyUSD", 1500, nil, "$1.50K"},
+		{"suffix:rpm", 3, nil, "3 rpm"},
+		{"none", 1, &two, "1.00"},
+		{"widgets", 2, nil, "2 widgets"},
+		{"fahrenheit", 70, nil, "70 °F"},
+
+		// durations are formatted by the steps of their unit
+		{"ns", 999, nil, "999 ns"},
+		{"ns", 1500, nil, "1.50 µs"},
+		{"ns", 9e10, nil, "1.50 min"},
+		{"ns", 1.728e14, nil, "2 day"},
+		{"µs", 1500, nil, "1.50 ms"},
+		{"µs", 1e8, nil, "100 s"},
+		{"ms", 999, nil, "999 ms"},
+		{"ms", 90000, nil, "1.50 min"},
+		{"ms", 1e9, nil, "11.6 day"},
+		{"ms", 6.3072e10, nil, "2 year"},
+		{"s", 0, nil, "0 s"},
+		{"s", 5e-7, nil, "500 ns"},
+		{"s", 2.5e-5, nil, "25 µs"},
+		{"s", 45, nil, "45 s"},
+		{"s", 5400, nil, "1.50 hour"},
+		{"s", 172800, nil, "2 day"},
+		{"s", 1209600, nil, "2 week"},
+		{"s", -120, nil, "-2 min"},
+		{"m", 0.5, nil, "30 s"},
+		{"m", 90, nil, "1.50 hour"},
+		{"m", 2880, nil, "2 day"},
+		{"m", 20160, nil, "2 week"},
+		{"h", 0.5, nil, "30 min"},
+		{"h", 0.001, &two, "3.60 s"},
+		{"h", 36, nil, "1.50 day"},
+		{"h", 336, nil, "2 week"},
+		{"h", 17520, nil, "2 year"},
+		{"d", 0.5, nil, "12 hour"},
+		{"d", 14, nil, "2 week"},
+		{"d", 730, nil, "2 year"},
+	}
+	for _, c := range cases {
+		prefix, text, suffix := FormatUnit(c.unit, c.value, c.decimals)
+		if got := prefix + text + suffix; got != c.want {
+			t.Errorf("%v of %v: wanted %q. got %q", c.unit, c.value, c.want, got)
+		}
+	}
+}
+
+func TestFieldConfigDisplay(t *testing.T) {
+	var panel Panel
+	err := json.Unmarshal([]byte(`{"id": 1, "fieldConfig": {
+		"defaults": {
+			"unit": "percentunit",
+			"thresholds": {"mode": "absolute", "steps": [{"value": null, "color": "green"}, {"value": 0.8, "color": "red"}]},
+			"mappings": [
+				{"type": "value", "options": {"1": {"text": "Full", "color": "purple", "index": 0}}},
+				{"type": "special", "options": {"match": "null", "result": {"text": "N/A", "index": 1}}}
+			]
+		},
+		"overrides": [{"matcher": {"id": "byName", "options": "Temp"}, "properties": [
+			{"id": "unit", "value": "celsius"},
+			{"id": "decimals", "value": 1},
+			{"id": "mappings", "value": [{"id": 0, "type": 2, "from": "100", "to": "", "text": "Hot"}]}
+		]}]
+	}}`), &panel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := Results{
+		FieldConfig: panel.FieldConfig,
+		Results:     map[string]Result{},
+	}
+	frame := Frame{Schema: Schema{Fields: []Field{
+		{Name: "Time", Type: "time"},
+		{Name: "Value", Type: "number"},
+		{Name: "Temp", Type: "number"},
+	}}}
+
+	usage := results.FieldConfigFor("A", frame, 1)
+	if v := usage.Display(0.87); v.String() != "87%" || v.Color != "red" {
+		t.Errorf("unexpected display %+v", v)
+	}
+	if v := usage.Display(0.5); v.String() != "50%" || v.Color != "green" {
+		t.Errorf("unexpected display %+v", v)
+	}
+	if v := usage.Display(1.0); v.String() != "Full" || v.Color != "purple" || !v.Mapped {
+		t.Errorf("unexpected display %+v", v)
+	}
+	if v := usage.Display(nil); v.String() != "N/A" {
+		t.Errorf("unexpected display %+v", v)
+	}
+
+	temp := results.FieldConfigFor("A", frame, 2)
+	if v := temp.Display(21.26); v.String() != "21.3 °C" {
+		t.Errorf("unexpected display %+v", v)
+	}
+	if v := temp.Display(120.0); v.String() != "Hot" || v.Color != "red" {
+		t.Errorf("unexpected display %+v", v)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	var frame Frame
+	err := json.Unmarshal([]byte(`{
+		"schema": {"fields": [{"name": "Value", "type": "number"}]},
+		"data": {"values": [[null, 1, 3, 3, null, 2, 5]]}
+	}`), &frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reduced, err := Reduce(frame.Data.Values[0], "first", "firstNotNull", "lastNotNull", "mean", "min", "max", "sum",
+		"count", "range", "delta", "diff", "changeCount", "distinctCount", "median", "p50", "allIsNull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"first":         nil,
+		"firstNotNull":  1.0,
+		"lastNotNull":   5.0,
+		"mean":          2.8,
+		"min":           1.0,
+		"max":           5.0,
+		"sum":           14.0,
+		"count":         7.0,
+		"range":         4.0,
+		"delta":         7.0, // 2 after 3 is a counter reset, counting 5 from zero
+		"diff":          4.0,
+		"changeCount":   5.0,
+		"distinctCount": 5.0,
+		"median":        3.0,
+		"p50":           3.0,
+		"allIsNull":     false,
+	}
+	if !reflect.DeepEqual(reduced, want) {
+		t.Errorf("unexpected reductions %v", reduced)
+	}
+
+	nulls := Column{Kind: KindFloat64, Floats: []float64{0, 0}, Nulls: []bool{true, true}}
+	reduced, _ = Reduce(nulls, "max", "mean", "count", "allIsNull", "allIsZero")
+	if reduced["max"] != nil || reduced["mean"] != nil || reduced["count"] != 2.0 || reduced["allIsNull"] != true || reduced["allIsZero"] != false {
+		t.Errorf("unexpected reductions of nulls %v", reduced)
+	}
+
+	nan := Column{Kind: KindFloat64, Floats: []float64{1, math.NaN(), 3}}
+	reduced, _ = Reduce(nan, "sum", "max")
+	if sum := reduced["sum"].(float64); !math.IsNaN(sum) || reduced["max"] != 3.0 {
+		t.Errorf("unexpected reductions with NaN %v", reduced)
+	}
+
+	if _, err := Reduce(nan, "median", "nope"); !errors.Is(err, ErrUnknownReducer) {
+		t.Errorf("wanted ErrUnknownReducer. got %v", err)
+	}
+}
+
+func TestStatValues(t *testing.T) {
+	var panel Panel
+	err := json.Unmarshal([]byte(`{"id": 1, "type": "stat",
+		"options": {"reduceOptions": {"values": false, "calcs": ["mean", "max"], "fields": ""}},
+		"fieldConfig": {"defaults": {"unit": "percentunit"}, "overrides": []}
+	}`), &panel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results Results
+	err = json.Unmarshal([]byte(`{"results": {"A": {"frames": [{
+		"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "host", "type": "string"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]},
+		"data": {"values": [[1000, 2000], ["x", "y"], [0.5, 0.7]]}
+	}]}}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.FieldConfig = panel.FieldConfig
+	results.ReduceOptions = panelReduceOptions(panel)
+
+	values, err := results.StatValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("expected 2 values. got %+v", values)
+	}
+	if values[0].Calc != "mean" || values[0].Display.String() != "60%" || values[0].Name != "a" {
+		t.Errorf("unexpected mean %+v", values[0])
+	}
+	if values[1].Calc != "max" || values[1].Value != 0.7 {
+		t.Errorf("unexpected max %+v", values[1])
+	}
+
+	values, err = results.ReduceFields(ReduceOptions{Values: true, Fields: "/.*/", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0].Value != "x" || values[2].Display.String() != "50%" {
+		t.Errorf("unexpected values %+v", values)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {
+		"A": {"frames": [{
+			"schema": {"refId": "A", "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]},
+			"data": {"values": [[1000, 2000, 3000], [1, 2, 3]]}
+		}]},
+		"B": {"frames": [{
+			"schema": {"refId": "B", "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "b"}}]},
+			"data": {"values": [[2000, 3000, 4000], [20, 30, 40]]}
+		}]}
+	}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Legends = map[string]string{"A": "{{instance}}", "B": "{{instance}}"}
+
+	var transformations []Transformation
+	err = json.Unmarshal([]byte(`[
+		{"id": "joinByField", "options": {}},
+		{"id": "organize", "options": {"renameByName": {"b": "beta"}}},
+		{"id": "calculateField", "options": {"mode": "binary", "binary": {"left": "a", "operator": "+", "right": "beta"}, "alias": "sum"}},
+		{"id": "sortBy", "options": {"sort": [{"field": "Time", "desc": true}]}},
+		{"id": "limit", "options": {"limitField": 3}},
+		{"id": "reduce", "options": {"reducers": ["sum", "max"]}}
+	]`), &transformations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformed, err := results.Transform(transformations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := transformed.Results["A"].Frames
+	if len(frames) != 1 || len(transformed.Results["B"].Frames) != 0 {
+		t.Fatalf("expected a single frame of A. got %+v", transformed.Results)
+	}
+	frame := frames[0]
+	var got [][]any
+	for row := 0; row < frame.Rows(); row++ {
+		var values []any
+		for _, col := range frame.Data.Values {
+			values = append(values, col.Value(row))
+		}
+		got = append(got, values)
+	}
+	// the join has rows 1000 to 4000, of which the limit keeps the last three
+	expected := [][]any{{"a", 5.0, 3.0}, {"beta", 90.0, 40.0}, {"sum", 55.0, 33.0}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v. got %v", expected, got)
+	}
+	if name := transformed.FieldDisplayName("A", frame, 2); name != "Max" {
+		t.Errorf("expected Max. got %v", name)
+	}
+
+	transformed, err = results.Transform([]Transformation{
+		{ID: "filterByValue", Options: map[string]any{"filters": []any{map[string]any{"fieldName": "a", "config": map[string]any{"id": "greater", "options": map[string]any{"value": 1}}}}}},
+		{ID: "configFromData"},
+	})
+	if !errors.Is(err, ErrUnsupportedTransformation) {
+		t.Errorf("expected ErrUnsupportedTransformation. got %v", err)
+	}
+	if rows := transformed.Results["A"].Frames[0].Rows(); rows != 2 {
+		t.Errorf("expected 2 rows of A. got %v", rows)
+	}
+	if rows := transformed.Results["B"].Frames[0].Rows(); rows != 3 {
+		t.Errorf("expected 3 rows of B. got %v", rows)
+	}
+}
+
+func TestTableExport(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {
+		"A": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "a"}}]},
+			"data": {"values": [[1000, 2000, 3000], [1, 2, 3]]}
+		}]},
+		"B": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"instance": "b", "job": "node"}}]},
+			"data": {"values": [[2100, 4000], [20, 40]]}
+		}]}
+	}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results.Legends = map[string]string{"A": "{{instance}}"}
+
+	values := func(table Table) [][]any {
+		var rows [][]any
+		for _, row := range table.Rows {
+			values := []any{row[0].(time.Time).UnixMilli()}
+			rows = append(rows, append(values, row[1:]...))
+		}
+		return rows
+	}
+
+	wide := results.WideTable()
+	if len(wide.Columns) != 3 || wide.Columns[1].Name != "a" || wide.Columns[2].Name != `{instance="b", job="node"}` {
+		t.Fatalf("unexpected columns %+v", wide.Columns)
+	}
+	expected := [][]any{{int64(1000), 1.0, nil}, {int64(2000), 2.0, nil}, {int64(2100), nil, 20.0}, {int64(3000), 3.0, nil}, {int64(4000), nil, 40.0}}
+	if got := values(wide); !reflect.DeepEqual(got, expected) {
+		t.Errorf("outer: expected %v. got %v", expected, got)
+	}
+
+	expected = [][]any{{int64(1000), 1.0, nil}, {int64(2000), 2.0, 20.0}, {int64(3000), 3.0, nil}}
+	if got := values(results.WideTable(WithAlignment(AlignNearest))); !reflect.DeepEqual(got, expected) {
+		t.Errorf("nearest: expected %v. got %v", expected, got)
+	}
+
+	expected = [][]any{{int64(1000), 1.0, nil}, {int64(2000), 2.0, nil}, {int64(2100), 2.0, 20.0}, {int64(3000), 3.0, 20.0}, {int64(4000), 3.0, 40.0}}
+	if got := values(results.WideTable(WithAlignment(AlignStepFill))); !reflect.DeepEqual(got, expected) {
+		t.Errorf("stepfill: expected %v. got %v", expected, got)
+	}
+
+	if got := results.WideTable(WithAlignment(AlignInner)); len(got.Rows) != 0 {
+		t.Errorf("inner: expected no rows. got %v", got.Rows)
+	}
+
+	long := results.LongTable()
+	expected = [][]any{
+		{int64(1000), "a", "a", nil, 1.0},
+		{int64(2000), "a", "a", nil, 2.0},
+		{int64(2100), `{instance="b", job="node"}`, "b", "node", 20.0},
+		{int64(3000), "a", "a", nil, 3.0},
+		{int64(4000), `{instance="b", job="node"}`, "b", "node", 40.0},
+	}
+	if got := values(long); !reflect.DeepEqual(got, expected) {
+		t.Errorf("long: expected %v. got %v", expected, got)
+	}
+
+	var buf bytes.Buffer
+	if err := long.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || lines[0] != "Time,Series,instance,job,Value" || !strings.HasSuffix(lines[1], ",a,a,,1") {
+		t.Errorf("unexpected csv %v", buf.String())
+	}
+}
+
+// transformedFrames returns the display names and rows of the frames of results, times as epoch milliseconds.
+func transformedFrames(results Results) (names [][]string, rows [][][]any) {
+	refs := make([]string, 0, len(results.Results))
+	for ref := range results.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	for _, ref := range refs {
+		for _, frame := range results.Results[ref].Frames {
+			var frameNames []string
+			for i := range frame.Data.Values {
+				frameNames = append(frameNames, results.FieldDisplayName(ref, frame, i))
+			}
+			var frameRows [][]any
+			for row := 0; row < frame.Rows(); row++ {
+				var values []any
+				for _, col := range frame.Data.Values {
+					v := col.Value(row)
+					if ts, ok := v.(time.Time); ok {
+						v = ts.UnixMilli()
+					}
+					values = append(values, v)
+				}
+				frameRows = append(frameRows, values)
+			}
+			names = append(names, frameNames)
+			rows = append(rows, frameRows)
+		}
+	}
+	return names, rows
+}
+
+func TestTransformers(t *testing.T) {
+	table := `{"results": {"A": {"frames": [{
+		"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "host", "type": "string"}, {"name": "cpu", "type": "number"}, {"name": "mem", "type": "number"}]},
+		"data": {"values": [[1000, 2000, 3000, 4000], ["a", "b", "a", "b"], [1, 2, 3, 4], [10, null, 30, 40]]}
+	}]}}}`
+	series := `{"results": {
+		"A": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"dc": "x", "host": "a"}}]},
+			"data": {"values": [[1000, 2000], [1, 2]]}
+		}]},
+		"B": {"frames": [{
+			"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"host": "b"}}]},
+			"data": {"values": [[1000, 3000], [5, 6]]}
+		}]}
+	}}`
+	seriesB := [][]any{{int64(1000), 5.0}, {int64(3000), 6.0}}
+
+	tests := []struct {
+		name            string
+		results         string
+		transformations string
+		names           [][]string
+		rows            [][][]any
+	}{
+		{
+			name:            "organize",
+			results:         table,
+			transformations: `[{"id": "organize", "options": {"excludeByName": {"mem": true}, "indexByName": {"cpu": 0, "host": 1, "Time": 2}, "renameByName": {"cpu": "CPU"}}}]`,
+			names:           [][]string{{"CPU", "host", "Time"}},
+			rows:            [][][]any{{{1.0, "a", int64(1000)}, {2.0, "b", int64(2000)}, {3.0, "a", int64(3000)}, {4.0, "b", int64(4000)}}},
+		},
+		{
+			name:            "filterFieldsByName include",
+			results:         table,
+			transformations: `[{"id": "filterFieldsByName", "options": {"include": {"pattern": "/^(Time|c)/"}}}, {"id": "limit", "options": {"limitField": 1}}]`,
+			names:           [][]string{{"Time", "cpu"}},
+			rows:            [][][]any{{{int64(1000), 1.0}}},
+		},
+		{
+			name:            "filterFieldsByName exclude",
+			results:         table,
+			transformations: `[{"id": "filterFieldsByName", "options": {"exclude": {"names": ["cpu", "mem"]}}}, {"id": "limit", "options": {"limitField": 2}}]`,
+			names:           [][]string{{"Time", "host"}},
+			rows:            [][][]any{{{int64(1000), "a"}, {int64(2000), "b"}}},
+		},
+		{
+			name:            "renameByRegex",
+			results:         table,
+			transformations: `[{"id": "renameByRegex", "options": {"regex": "(.*)u$", "renamePattern": "$1U"}}, {"id": "limit", "options": {"limitField": 0}}]`,
+			names:           [][]string{{"Time", "host", "cpU", "mem"}},
+			rows:            [][][]any{nil},
+		},
+		{
+			name:            "filterByValue exclude all",
+			results:         table,
+			transformations: `[{"id": "filterByValue", "options": {"type": "exclude", "match": "all", "filters": [{"fieldName": "cpu", "config": {"id": "greater", "options": {"value": 1}}}, {"fieldName": "host", "config": {"id": "equal", "options": {"value": "b"}}}]}}]`,
+			names:           [][]string{{"Time", "host", "cpu", "mem"}},
+			rows:            [][][]any{{{int64(1000), "a", 1.0, 10.0}, {int64(3000), "a", 3.0, 30.0}}},
+		},
+		{
+			name:            "filterByValue include null",
+			results:         table,
+			transformations: `[{"id": "filterByValue", "options": {"filters": [{"fieldName": "mem", "config": {"id": "isNull"}}]}}]`,
+			names:           [][]string{{"Time", "host", "cpu", "mem"}},
+			rows:            [][][]any{{{int64(2000), "b", 2.0, nil}}},
+		},
+		{
+			name:            "sortBy",
+			results:         table,
+			transformations: `[{"id": "sortBy", "options": {"sort": [{"field": "mem", "desc": true}]}}, {"id": "organize", "options": {"includeByName": {"mem": true}}}]`,
+			names:           [][]string{{"mem"}},
+			rows:            [][][]any{{{40.0}, {30.0}, {10.0}, {nil}}},
+		},
+		{
+			name:            "groupBy",
+			results:         table,
+			transformations: `[{"id": "groupBy", "options": {"fields": {"host": {"operation": "groupby"}, "cpu": {"operation": "aggregate", "aggregations": ["sum", "max"]}, "mem": {"operation": "aggregate", "aggregations": ["mean"]}}}}]`,
+			names:           [][]string{{"host", "cpu (sum)", "cpu (max)", "mem (mean)"}},
+			rows:            [][][]any{{{"a", 4.0, 3.0, 20.0}, {"b", 6.0, 4.0, 40.0}}},
+		},
+		{
+			name:            "calculateField reduceRow",
+			results:         table,
+			transformations: `[{"id": "calculateField", "options": {}}, {"id": "organize", "options": {"includeByName": {"Total": true}}}]`,
+			names:           [][]string{{"Total"}},
+			rows:            [][][]any{{{11.0}, {2.0}, {33.0}, {44.0}}},
+		},
+		{
+			name:            "calculateField binary",
+			results:         table,
+			transformations: `[{"id": "calculateField", "options": {"mode": "binary", "binary": {"left": "mem", "operator": "/", "right": "cpu"}, "replaceFields": true}}]`,
+			names:           [][]string{{"Time", "mem / cpu"}},
+			rows:            [][][]any{{{int64(1000), 10.0}, {int64(2000), nil}, {int64(3000), 10.0}, {int64(4000), 10.0}}},
+		},
+		{
+			name:            "calculateField index",
+			results:         table,
+			transformations: `[{"id": "calculateField", "options": {"mode": "index", "replaceFields": true}}, {"id": "limit", "options": {"limitField": 2}}]`,
+			names:           [][]string{{"Time", "Row"}},
+			rows:            [][][]any{{{int64(1000), 0.0}, {int64(2000), 1.0}}},
+		},
+		{
+			name:            "reduce reduceFields",
+			results:         table,
+			transformations: `[{"id": "reduce", "options": {"mode": "reduceFields", "reducers": ["max"]}}]`,
+			names:           [][]string{{"cpu", "mem"}},
+			rows:            [][][]any{{{4.0, 40.0}}},
+		},
+		{
+			name:            "reduce reduceFields with several reducers",
+			results:         table,
+			transformations: `[{"id": "reduce", "options": {"mode": "reduceFields", "reducers": ["min", "max"]}}]`,
+			names:           [][]string{{"cpu Min", "cpu Max", "mem Min", "mem Max"}},
+			rows:            [][][]any{{{1.0, 4.0, 10.0, 40.0}}},
+		},
+		{
+			name:            "reduce seriesToRows",
+			results:         series,
+			transformations: `[{"id": "reduce", "options": {"reducers": ["max", "sum"]}}]`,
+			names:           [][]string{{"Field", "Max", "Total"}},
+			rows:            [][][]any{{{`{dc="x", host="a"}`, 2.0, 3.0}, {`{host="b"}`, 6.0, 11.0}}},
+		},
+		{
+			name:            "reduce seriesToRows with labels",
+			results:         series,
+			transformations: `[{"id": "reduce", "options": {"reducers": ["max"], "labelsToFields": true}}]`,
+			names:           [][]string{{"Field", "dc", "host", "Max"}},
+			rows:            [][][]any{{{"Value", "x", "a", 2.0}, {"Value", nil, "b", 6.0}}},
+		},
+		{
+			name:            "merge",
+			results:         series,
+			transformations: `[{"id": "merge", "options": {}}]`,
+			names:           [][]string{{"Time", `{dc="x", host="a"}`, `{host="b"}`}},
+			rows:            [][][]any{{{int64(1000), 1.0, 5.0}, {int64(2000), 2.0, nil}, {int64(3000), nil, 6.0}}},
+		},
+		{
+			name:            "joinByField inner",
+			results:         series,
+			transformations: `[{"id": "joinByField", "options": {"byField": "Time", "mode": "inner"}}]`,
+			names:           [][]string{{"Time", `{dc="x", host="a"}`, `{host="b"}`}},
+			rows:            [][][]any{{{int64(1000), 1.0, 5.0}}},
+		},
+		{
+			name:            "labelsToFields",
+			results:         series,
+			transformations: `[{"id": "labelsToFields", "options": {}, "filter": {"id": "byRefId", "options": "A"}}]`,
+			names:           [][]string{{"Time", "dc", "host", "Value"}, {"Time", "b"}},
+			rows:            [][][]any{{{int64(1000), "x", "a", 1.0}, {int64(2000), "x", "a", 2.0}}, seriesB},
+		},
+		{
+			name:            "labelsToFields valueLabel",
+			results:         series,
+			transformations: `[{"id": "labelsToFields", "options": {"valueLabel": "host"}, "filter": {"id": "byRefId", "options": "A"}}]`,
+			names:           [][]string{{"Time", "dc", "a"}, {"Time", "b"}},
+			rows:            [][][]any{{{int64(1000), "x", 1.0}, {int64(2000), "x", 2.0}}, seriesB},
+		},
+		{
+			name:            "labelsToFields keepLabels",
+			results:         series,
+			transformations: `[{"id": "labelsToFields", "options": {"keepLabels": ["host"]}, "filter": {"id": "byRefId", "options": "A"}}]`,
+			names:           [][]string{{"Time", "host", "Value"}, {"Time", "b"}},
+			rows:            [][][]any{{{int64(1000), "a", 1.0}, {int64(2000), "a", 2.0}}, seriesB},
+		},
+		{
+			name:            "labelsToFields rows",
+			results:         series,
+			transformations: `[{"id": "labelsToFields", "options": {"mode": "rows"}, "filter": {"id": "byRefId", "options": "A"}}]`,
+			names:           [][]string{{"label", "value"}, {"Time", "b"}},
+			rows:            [][][]any{{{"dc", "x"}, {"host", "a"}}, seriesB},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results Results
+			if err := json.Unmarshal([]byte(tt.results), &results); err != nil {
+				t.Fatal(err)
+			}
+			var transformations []Transformation
+			if err := json.Unmarshal([]byte(tt.transformations), &transformations); err != nil {
+				t.Fatal(err)
+			}
+
+			transformed, err := results.Transform(transformations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, rows := transformedFrames(transformed)
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("expected names %v. got %v", tt.names, names)
+			}
+			if !reflect.DeepEqual(rows, tt.rows) {
+				t.Errorf("expected rows %v. got %v", tt.rows, rows)
+			}
+		})
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	var results Results
+	err := json.Unmarshal([]byte(`{"results": {"A": {"frames": [{
+		"schema": {"fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number"}]},
+		"data": {"values": [[1000, 2000], [1, 2]]}
+	}]}}}`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformed, err := results.Transform([]Transformation{
+		{ID: "limit", Options: map[string]any{"limitField": 1}},
+		{ID: "renameByRegex", Options: map[string]any{"regex": "/(/"}},
+	})
+	if err == nil || errors.Is(err, ErrUnsupportedTransformation) {
+		t.Fatalf("expected the invalid regex to be reported. got %v", err)
+	}
+	if rows := transformed.Results["A"].Frames[0].Rows(); rows != 2 {
+		t.Errorf("expected untransformed results. got %v rows", rows)
+	}
+
+	transformed, err = results.Transform([]Transformation{{ID: "configFromData"}, {ID: "limit", Options: map[string]any{"limitField": 1}}})
+	if !errors.Is(err, ErrUnsupportedTransformation) || transformed.Err() != nil {
+		t.Errorf("expected ErrUnsupportedTransformation. got %v", err)
+	}
+	if rows := transformed.Results["A"].Frames[0].Rows(); rows != 1 {
+		t.Errorf("expected the supported transformations to apply. got %v rows", rows)
+	}
+}
+
+func TestGetPanelDataTransformations(t *testing.T) {
+	dashboard := `{"dashboard": {"panels": [
+		{"id": 1, "datasource": {"type": "prometheus", "uid": "p1"},
+			"targets": [{"refId": "A", "expr": "cpu", "legendFormat": "{{host}}"}, {"refId": "B", "expr": "mem", "legendFormat": "{{host}}"}],
+			"transformations": [
+				{"id": "joinByField", "options": {}},
+				{"id": "organize", "options": {"renameByName": {"b": "beta"}}},
+				{"id": "sortBy", "options": {"sort": [{"field": "Time", "desc": true}]}, "disabled": true}
+			]},
+		{"id": 2, "datasource": {"type": "prometheus", "uid": "p1"},
+			"targets": [{"refId": "A", "expr": "cpu"}],
+			"transformations": [{"id": "configFromData", "options": {}}]},
+		{"id": 3, "datasource": {"type": "prometheus", "uid": "p1"},
+			"targets": [{"refId": "A", "expr": "cpu"}],
+			"transformations": [{"id": "renameByRegex", "options": {"regex": "/(/"}}]}
+	]}}`
+	results := `{"results": {
+		"A": {"status": 200, "frames": [{
+			"schema": {"refId": "A", "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"host": "a"}}]},
+			"data": {"values": [[1000, 2000], [1, 2]]}
+		}]},
+		"B": {"status": 200, "frames": [{
+			"schema": {"refId": "B", "fields": [{"name": "Time", "type": "time"}, {"name": "Value", "type": "number", "labels": {"host": "b"}}]},
+			"data": {"values": [[2000, 3000], [20, 30]]}
+		}]}
+	}}`
+
+	g := CreateMockGrafanaClient(t, &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := dashboard
+			if req.Method == http.MethodPost {
+				body = results
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}, nil
+		},
+	})
+	g.cacheDatasources(Datasource{UID: "p1", Type: "prometheus"})
+
+	result, err := g.GetPanelDataFromID("foo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := transformedFrames(result); len(names) != 2 {
+		t.Errorf("expected untransformed frames without WithTransformations. got %v", names)
+	}
+
+	result, err = g.GetPanelDataFromID("foo", 1, WithTransformations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, rows := transformedFrames(result)
+	expectedNames := [][]string{{"Time", "a", "beta"}}
+	expectedRows := [][][]any{{{int64(1000), 1.0, nil}, {int64(2000), 2.0, 20.0}, {int64(3000), nil, 30.0}}}
+	if !reflect.DeepEqual(names, expectedNames) || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("expected %v %v. got %v %v", expectedNames, expectedRows, names, rows)
+	}
+
+	// unsupported transformations are skipped, even when errors are fatal
+	result, err = g.GetPanelDataFromID("foo", 2, WithTransformations(), WithQueryErrorsFatal(), WithTransformErrorsFatal())
+	if err != nil || result.Err() != nil {
+		t.Fatalf("wanted results without error. got %v, %v", err, result.Err())
+	}
+
+	_, err = g.GetPanelDataFromID("foo", 3, WithTransformations())
+	if err != nil {
+		t.Fatalf("wanted results without error. got %v", err)
+	}
+	_, err = g.GetPanelDataFromID("foo", 3, WithTransformations(), WithTransformErrorsFatal())
+	if err == nil {
+		t.Error("expected the failed transformation to be fatal")
+	}
+}
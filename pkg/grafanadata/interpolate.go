@@ -0,0 +1,457 @@
+package grafanadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// allValue is the value Grafana stores when the "All" option of a variable is selected.
+const allValue = "$__all"
+
+// variableRegex matches $var, [[var]], [[var:format]], ${var}, ${var.fieldPath} and ${var:format}.
+// It is the same expression Grafana's template service uses.
+var variableRegex = regexp.MustCompile(`\$(\w+)|\[\[(\w+?)(?::(\w+))?\]\]|\$\{(\w+)(?:\.([^:^\}]+))?(?::([^\}]+))?\}`)
+
+// VariableValue is the selected value of a template variable together with the
+// metadata Grafana uses to format it.
+type VariableValue struct {
+	Values     []string
+	Multi      bool
+	IncludeAll bool
+	AllValue   string   // custom value used when "All" is selected
+	Options    []string // every option of the variable, used to expand "All" without a custom value
+}
+
+// IsAll reports whether the "All" option is selected.
+func (v VariableValue) IsAll() bool {
+	for _, value := range v.Values {
+		if value == allValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Interpolator replaces template variables in queries following Grafana's rules.
+// Built-in variables such as $__interval or $__from are only replaced when the
+// matching field is set, otherwise they are left for Grafana's backend to resolve.
+type Interpolator struct {
+	Variables      map[string]VariableValue
+	From           time.Time
+	To             time.Time
+	Interval       time.Duration
+	ScrapeInterval time.Duration // used to compute $__rate_interval, defaults to 15s
+}
+
+// NewInterpolator creates an Interpolator for the given variable values.
+func NewInterpolator(vars map[string]VariableValue) *Interpolator {
+	if vars == nil {
+		vars = map[string]VariableValue{}
+	}
+	return &Interpolator{Variables: vars}
+}
+
+// newDashboardInterpolator merges the variable metadata of a dashboard with the selected values.
+func newDashboardInterpolator(list []TemplateVariable, values map[string][]string) *Interpolator {
+	vars := make(map[string]VariableValue, len(values))
+	for _, tpl := range list {
+		v := VariableValue{
+			Multi:      tpl.Multi,
+			IncludeAll: tpl.IncludeAll,
+			AllValue:   tpl.AllValue,
+		}
+		for _, o := range tpl.Options {
+			if o.Value != allValue {
+				v.Options = append(v.Options, o.Value)
+			}
+		}
+		selected, ok := values[tpl.Name]
+		if !ok {
+			continue
+		}
+		v.Values = selected
+		vars[tpl.Name] = v
+	}
+
+	for name, selected := range values {
+		if _, ok := vars[name]; !ok {
+			vars[name] = VariableValue{Values: selected, Multi: len(selected) > 1}
+		}
+	}
+
+	return NewInterpolator(vars)
+}
+
+// Interpolate replaces every variable reference in s. Variables without an explicit
+// format are formatted the way the given datasource type (e.g. "prometheus") expects.
+// Unknown variables are left untouched.
+func (ip *Interpolator) Interpolate(s string, datasourceType string) string {
+	return ip.interpolate(s, datasourceType, 0)
+}
+
+// maxInterpolationDepth bounds the recursion into custom all values that reference other variables.
+const maxInterpolationDepth = 10
+
+func (ip *Interpolator) interpolate(s string, datasourceType string, depth int) string {
+	if ip == nil || (!strings.Contains(s, "$") && !strings.Contains(s, "[[")) {
+		return s
+	}
+
+	return variableRegex.ReplaceAllStringFunc(s, func(match string) string {
+		groups := variableRegex.FindStringSubmatch(match)
+
+		name, format := groups[1], ""
+		switch {
+		case groups[2] != "":
+			name, format = groups[2], groups[3]
+		case groups[4] != "":
+			name, format = groups[4], groups[6]
+		}
+
+		if strings.HasPrefix(name, "__") {
+			if value, ok := ip.builtin(name, format); ok {
+				return value
+			}
+			return match
+		}
+
+		v, ok := ip.Variables[name]
+		if !ok {
+			return match
+		}
+
+		values := v.Values
+		if v.IsAll() {
+			// custom all values are not formatted, but may reference other variables
+			if v.AllValue != "" && format != "text" && format != "percentencode" {
+				if depth >= maxInterpolationDepth {
+					return v.AllValue
+				}
+				return ip.interpolate(v.AllValue, datasourceType, depth+1)
+			}
+			switch {
+			case v.AllValue != "":
+				values = []string{v.AllValue}
+			case len(v.Options) == 0 && (datasourceType == "prometheus" || datasourceType == "loki"):
+				// the options of query variables are usually not saved with the dashboard,
+				// matching everything is equivalent for the regex matchers multi values need
+				return ".*"
+			default:
+				values = v.Options
+			}
+		}
+
+		if format == "" {
+			return formatForDatasource(name, values, v, datasourceType)
+		}
+		return formatValues(name, values, format)
+	})
+}
+
+// builtin resolves Grafana's global variables.
+func (ip *Interpolator) builtin(name, format string) (string, bool) {
+	rangeDur := ip.To.Sub(ip.From)
+	hasRange := !ip.From.IsZero() && !ip.To.IsZero()
+
+	switch name {
+	case "__from", "__to":
+		t := ip.From
+		if name == "__to" {
+			t = ip.To
+		}
+		if t.IsZero() {
+			return "", false
+		}
+		return formatTime(t, format), true
+	case "__range":
+		if !hasRange {
+			return "", false
+		}
+		return formatGrafanaDuration(rangeDur), true
+	case "__range_s":
+		if !hasRange {
+			return "", false
+		}
+		return strconv.FormatInt(int64(rangeDur/time.Second), 10), true
+	case "__range_ms":
+		if !hasRange {
+			return "", false
+		}
+		return strconv.FormatInt(rangeDur.Milliseconds(), 10), true
+	case "__interval":
+		if ip.Interval <= 0 {
+			return "", false
+		}
+		return formatGrafanaDuration(ip.Interval), true
+	case "__interval_ms":
+		if ip.Interval <= 0 {
+			return "", false
+		}
+		return strconv.FormatInt(ip.Interval.Milliseconds(), 10), true
+	case "__rate_interval":
+		if ip.Interval <= 0 {
+			return "", false
+		}
+		return formatGrafanaDuration(ip.rateInterval()), true
+	case "__rate_interval_ms":
+		if ip.Interval <= 0 {
+			return "", false
+		}
+		return strconv.FormatInt(ip.rateInterval().Milliseconds(), 10), true
+	}
+
+	return "", false
+}
+
+// rateInterval is max($__interval + scrape interval, 4 * scrape interval), as in Grafana.
+func (ip *Interpolator) rateInterval() time.Duration {
+	scrape := ip.ScrapeInterval
+	if scrape <= 0 {
+		scrape = 15 * time.Second
+	}
+	return max(ip.Interval+scrape, 4*scrape)
+}
+
+// formatTime renders $__from and $__to. Without a format the value is epoch milliseconds.
+func formatTime(t time.Time, format string) string {
+	ms := strconv.FormatInt(t.UnixMilli(), 10)
+
+	switch {
+	case format == "":
+		return ms
+	case format == "date" || format == "date:iso":
+		return t.UTC().Format("2006-01-02T15:04:05.000Z")
+	case format == "date:seconds":
+		return strconv.FormatInt(t.Unix(), 10)
+	case strings.HasPrefix(format, "date:"):
+		return formatMoment(t, strings.TrimPrefix(format, "date:"))
+	}
+
+	return formatValues("", []string{ms}, format)
+}
+
+// momentTokens maps the moment.js tokens commonly used in ${__from:date:...} to Go layouts.
+var momentTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"}, {"YY", "06"}, {"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"},
+	{"DD", "02"}, {"dddd", "Monday"}, {"ddd", "Mon"}, {"HH", "15"}, {"hh", "03"},
+	{"mm", "04"}, {"ss", "05"}, {"SSS", "000"}, {"A", "PM"}, {"Z", "-07:00"},
+}
+
+func formatMoment(t time.Time, format string) string {
+	var layout strings.Builder
+	for i := 0; i < len(format); {
+		matched := false
+		for _, tok := range momentTokens {
+			if strings.HasPrefix(format[i:], tok.token) {
+				layout.WriteString(tok.layout)
+				i += len(tok.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			layout.WriteByte(format[i])
+			i++
+		}
+	}
+	return t.Format(layout.String())
+}
+
+// formatGrafanaDuration renders a duration using only its largest unit, like Grafana's secondsToHms.
+func formatGrafanaDuration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+
+	if years := seconds / 31536000; years > 0 {
+		return fmt.Sprintf("%dy", years)
+	}
+	if days := seconds % 31536000 / 86400; days > 0 {
+		return fmt.Sprintf("%dd", days)
+	}
+	if hours := seconds % 86400 / 3600; hours > 0 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	if minutes := seconds % 3600 / 60; minutes > 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	if seconds > 0 {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	if ms := d.Milliseconds(); ms > 0 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return "less than a millisecond"
+}
+
+// formatForDatasource applies the default formatting a datasource uses when a
+// variable is referenced without an explicit format.
+func formatForDatasource(name string, values []string, v VariableValue, datasourceType string) string {
+	multi := v.Multi || v.IncludeAll
+
+	switch datasourceType {
+	case "prometheus":
+		if !multi {
+			return prometheusRegularEscape(strings.Join(values, ","))
+		}
+		escaped := make([]string, len(values))
+		for i := range values {
+			escaped[i] = prometheusSpecialRegexEscape(values[i])
+		}
+		if len(escaped) == 1 {
+			return escaped[0]
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	case "loki":
+		if !multi {
+			return strings.Join(values, ",")
+		}
+		escaped := make([]string, len(values))
+		for i := range values {
+			escaped[i] = prometheusSpecialRegexEscape(values[i])
+		}
+		return strings.Join(escaped, "|")
+	case "influxdb", "graphite":
+		if !multi {
+			return strings.Join(values, ",")
+		}
+		return formatValues(name, values, "regex")
+	case "mysql", "postgres", "grafana-postgresql-datasource", "mssql":
+		if !multi {
+			return strings.Join(values, ",")
+		}
+		return formatValues(name, values, "sqlstring")
+	case "elasticsearch":
+		return formatValues(name, values, "lucene")
+	}
+
+	return formatValues(name, values, "glob")
+}
+
+// formatValues applies one of Grafana's variable format modifiers.
+func formatValues(name string, values []string, format string) string {
+	format, _, _ = strings.Cut(format, ":")
+
+	mapJoin := func(sep string, fn func(string) string) string {
+		out := make([]string, len(values))
+		for i := range values {
+			out[i] = fn(values[i])
+		}
+		return strings.Join(out, sep)
+	}
+
+	switch format {
+	case "csv", "raw":
+		return strings.Join(values, ",")
+	case "pipe":
+		return strings.Join(values, "|")
+	case "text":
+		return strings.Join(values, " + ")
+	case "html":
+		return mapJoin(", ", html.EscapeString)
+	case "regex":
+		if len(values) == 1 {
+			return regexEscape(values[0])
+		}
+		return "(" + mapJoin("|", regexEscape) + ")"
+	case "json":
+		var b []byte
+		if len(values) == 1 {
+			b, _ = json.Marshal(values[0])
+		} else {
+			b, _ = json.Marshal(values)
+		}
+		return string(b)
+	case "queryparam":
+		return mapJoin("&", func(s string) string {
+			return "var-" + encodeURIComponentStrict(name) + "=" + encodeURIComponentStrict(s)
+		})
+	case "lucene":
+		if len(values) == 1 {
+			return luceneEscape(values[0])
+		}
+		return "(" + mapJoin(" OR ", func(s string) string { return `"` + luceneEscape(s) + `"` }) + ")"
+	case "distributed":
+		out := make([]string, len(values))
+		for i := range values {
+			if i == 0 {
+				out[i] = values[i]
+			} else {
+				out[i] = name + "=" + values[i]
+			}
+		}
+		return strings.Join(out, ",")
+	case "singlequote":
+		return mapJoin(",", func(s string) string { return "'" + strings.ReplaceAll(s, "'", `\'`) + "'" })
+	case "doublequote":
+		return mapJoin(",", func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"` })
+	case "sqlstring":
+		return mapJoin(",", func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" })
+	case "percentencode":
+		if len(values) == 1 {
+			return encodeURIComponentStrict(values[0])
+		}
+		return encodeURIComponentStrict("{" + strings.Join(values, ",") + "}")
+	case "uriencode":
+		if len(values) == 1 {
+			return encodeURI(values[0])
+		}
+		return encodeURI("{" + strings.Join(values, ",") + "}")
+	case "glob":
+		if len(values) == 1 {
+			return values[0]
+		}
+		return "{" + strings.Join(values, ",") + "}"
+	}
+
+	// unknown formats fall back to the glob format, like Grafana
+	return formatValues(name, values, "glob")
+}
+
+func prometheusRegularEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `'`, `\\'`)
+}
+
+var prometheusSpecialRegexChars = regexp.MustCompile(`[$^*{}\[\]'+?.()|]`)
+
+func prometheusSpecialRegexEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\\\`)
+	return prometheusSpecialRegexChars.ReplaceAllString(s, `\\$0`)
+}
+
+var regexSpecialChars = regexp.MustCompile(`[\\^$*+?.()|\[\]{}/]`)
+
+func regexEscape(s string) string {
+	return regexSpecialChars.ReplaceAllString(s, `\$0`)
+}
+
+var luceneSpecialChars = regexp.MustCompile(`[+\-&|!(){}\[\]^"~*?:\\/ ]`)
+
+func luceneEscape(s string) string {
+	return luceneSpecialChars.ReplaceAllString(s, `\$0`)
+}
+
+// encodeURIComponentStrict matches javascript's encodeURIComponent with !'()* also escaped.
+func encodeURIComponentStrict(s string) string {
+	s = url.QueryEscape(s)
+	return strings.ReplaceAll(s, "+", "%20")
+}
+
+// encodeURI matches javascript's encodeURI, leaving URI delimiters untouched.
+func encodeURI(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.IndexByte(";,/?:@&=+$-_.!~*'()#", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package grafanadata
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultIntervalMs is the fallback minimum interval (1 minute) used when a panel
+// has an interval configured but it cannot be parsed.
+const defaultIntervalMs = 60000
+
+// intervalUnits are the units of Grafana's duration grammar. Months and years have a
+// fixed length, like in Grafana.
+var intervalUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"M":  30 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// isAutoInterval reports whether the interval asks Grafana to calculate it, either
+// because it is empty or because it is the auto option of an interval variable.
+func isAutoInterval(interval string) bool {
+	interval = strings.TrimSpace(interval)
+	return interval == "" || strings.HasPrefix(interval, "$__auto")
+}
+
+// ParseInterval parses a Grafana interval such as "500ms", "30s", "1h30m", "1w" or
+// "1y". A leading ">" or "<", used for minimum intervals, is ignored. Numbers without
+// a unit are seconds.
+func ParseInterval(interval string) (time.Duration, error) {
+	s := strings.TrimSpace(interval)
+	s = strings.TrimLeft(s, "<>")
+	if s == "" {
+		return 0, fmt.Errorf("empty interval")
+	}
+
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid interval %q", interval)
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q: %w", interval, err)
+		}
+		s = s[i:]
+
+		unit := ""
+		switch {
+		case strings.HasPrefix(s, "ms"):
+			unit = "ms"
+		case s != "":
+			unit = s[:1]
+		}
+		d, ok := intervalUnits[unit]
+		if !ok {
+			return 0, fmt.Errorf("invalid interval %q: unknown unit %q", interval, unit)
+		}
+		s = s[len(unit):]
+
+		total += time.Duration(n * float64(d))
+	}
+
+	return total, nil
+}
+
+// parseIntervalMs parses a Grafana interval string (e.g. "1m", "500ms", "1h30m", ">2m")
+// and returns the equivalent value in milliseconds.
+// Returns 0 if the interval string is empty or an auto interval (panel has no interval configured).
+// Returns defaultIntervalMs if the interval is non-empty but cannot be parsed,
+// to avoid silently dropping the panel's intended minimum interval.
+func parseIntervalMs(interval string) int {
+	if isAutoInterval(interval) {
+		return 0
+	}
+
+	d, err := ParseInterval(interval)
+	if err != nil {
+		return defaultIntervalMs
+	}
+
+	return int(d.Milliseconds())
+}
+
+// roundInterval rounds an interval to one of the "nice" steps Grafana uses.
+func roundInterval(ms int64) int64 {
+	steps := []struct{ below, value int64 }{
+		{10, 1},
+		{15, 10},
+		{35, 20},
+		{75, 50},
+		{150, 100},
+		{350, 200},
+		{750, 500},
+		{1500, 1000},
+		{3500, 2000},
+		{7500, 5000},
+		{12500, 10000},
+		{17500, 15000},
+		{25000, 20000},
+		{45000, 30000},
+		{90000, 60000},
+		{210000, 120000},
+		{450000, 300000},
+		{750000, 600000},
+		{1050000, 900000},
+		{1500000, 1200000},
+		{2700000, 1800000},
+		{5400000, 3600000},
+		{9000000, 7200000},
+		{16200000, 10800000},
+		{32400000, 21600000},
+		{86400000, 43200000},
+		{604800000, 86400000},
+		{1814400000, 604800000},
+		{3628800000, 2592000000},
+	}
+	for _, step := range steps {
+		if ms < step.below {
+			return step.value
+		}
+	}
+	return 31536000000
+}
+
+// CalculateInterval returns the $__interval Grafana uses for a query: the time range
+// divided by maxDataPoints, rounded to a nice step and never below minInterval.
+func CalculateInterval(tr TimeRange, maxDataPoints int, minInterval time.Duration) time.Duration {
+	if maxDataPoints <= 0 {
+		maxDataPoints = defaultMaxDataPoints
+	}
+
+	intervalMs := roundInterval(tr.To.Sub(tr.From).Milliseconds() / int64(maxDataPoints))
+	if lowLimit := minInterval.Milliseconds(); lowLimit > intervalMs {
+		intervalMs = lowLimit
+	}
+
+	return time.Duration(intervalMs) * time.Millisecond
+}
+
+// getScrapeInterval returns the scrape interval (jsonData.timeInterval) configured on a
+// datasource.
+func (c *Client) getScrapeInterval(ctx context.Context, uid string) (time.Duration, error) {
+	ds, err := c.GetDatasourceByUIDContext(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	interval, _ := ds.JSONData["timeInterval"].(string)
+	if interval == "" {
+		return 0, nil
+	}
+
+	return ParseInterval(interval)
+}
@@ -0,0 +1,184 @@
+package grafanadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// libraryPanelKind is the kind of library elements that are panels.
+const libraryPanelKind = 1
+
+// libraryPageSize is the number of library elements requested per page when listing.
+const libraryPageSize = 100
+
+// DefaultLibraryPanelCacheTTL is how long library panels are cached unless configured
+// with WithLibraryPanelCacheTTL.
+const DefaultLibraryPanelCacheTTL = 5 * time.Minute
+
+// WithLibraryPanelCacheTTL sets how long the client caches library panels. A negative
+// TTL disables caching.
+func WithLibraryPanelCacheTTL(ttl time.Duration) ClientOption {
+	return func(client *Client) {
+		client.libraryTTL = ttl
+	}
+}
+
+// LibraryElement is a library panel shared between dashboards.
+type LibraryElement struct {
+	ID          int    `json:"id"`
+	UID         string `json:"uid"`
+	Name        string `json:"name"`
+	Kind        int    `json:"kind"`
+	Type        string `json:"type"` // panel type, e.g. "timeseries"
+	Description string `json:"description"`
+	FolderUID   string `json:"folderUid"`
+	Version     int    `json:"version"`
+	Model       Panel  `json:"model"`
+}
+
+// GetLibraryPanel retrieves a library panel by uid.
+func (c *Client) GetLibraryPanel(uid string) (LibraryElement, error) {
+	return c.GetLibraryPanelContext(context.Background(), uid)
+}
+
+// GetLibraryPanelContext retrieves a library panel by uid using the provided context.
+// Library panels are cached per client, see WithLibraryPanelCacheTTL.
+func (c *Client) GetLibraryPanelContext(ctx context.Context, uid string) (LibraryElement, error) {
+	c.mu.Lock()
+	cached, ok := c.libraryElements[uid]
+	c.mu.Unlock()
+	if ok && fresh(cached.fetched, c.libraryCacheTTL()) {
+		return cached.element.clone(), nil
+	}
+
+	var element LibraryElement
+
+	var response struct {
+		Result LibraryElement `json:"result"`
+	}
+	err := c.getJSON(ctx, "/api/library-elements/"+url.PathEscape(uid), nil, &response)
+	if errors.Is(err, ErrNotFound) {
+		return element, fmt.Errorf("%w: %v: %w", ErrLibraryPanelNotFound, uid, err)
+	}
+	if err != nil {
+		return element, fmt.Errorf("failed to get library panel %v with error %w", uid, err)
+	}
+
+	c.cacheLibraryElements(response.Result)
+
+	return response.Result, nil
+}
+
+// FetchLibraryPanels returns all the library panels of the grafana instance.
+func (c *Client) FetchLibraryPanels() ([]LibraryElement, error) {
+	return c.FetchLibraryPanelsContext(context.Background())
+}
+
+// FetchLibraryPanelsContext returns all the library panels of the grafana instance using the provided context.
+func (c *Client) FetchLibraryPanelsContext(ctx context.Context) ([]LibraryElement, error) {
+	return c.SearchLibraryPanelsContext(ctx, "")
+}
+
+// SearchLibraryPanels returns the library panels whose name or description matches query.
+func (c *Client) SearchLibraryPanels(query string) ([]LibraryElement, error) {
+	return c.SearchLibraryPanelsContext(context.Background(), query)
+}
+
+// SearchLibraryPanelsContext returns the library panels whose name or description matches query
+// using the provided context.
+func (c *Client) SearchLibraryPanelsContext(ctx context.Context, query string) ([]LibraryElement, error) {
+	var elements []LibraryElement
+
+	for page := 1; ; page++ {
+		params := url.Values{
+			"kind":    {strconv.Itoa(libraryPanelKind)},
+			"page":    {strconv.Itoa(page)},
+			"perPage": {strconv.Itoa(libraryPageSize)},
+		}
+		if query != "" {
+			params.Set("searchString", query)
+		}
+
+		var response struct {
+			Result struct {
+				TotalCount int              `json:"totalCount"`
+				Elements   []LibraryElement `json:"elements"`
+			} `json:"result"`
+		}
+		if err := c.getJSON(ctx, "/api/library-elements", params, &response); err != nil {
+			return elements, fmt.Errorf("failed to search library panels with error %w", err)
+		}
+
+		elements = append(elements, response.Result.Elements...)
+		if len(response.Result.Elements) == 0 || len(elements) >= response.Result.TotalCount {
+			break
+		}
+	}
+
+	c.cacheLibraryElements(elements...)
+
+	return elements, nil
+}
+
+type cachedLibraryElement struct {
+	element LibraryElement
+	fetched time.Time
+}
+
+func (c *Client) libraryCacheTTL() time.Duration {
+	if c.libraryTTL == 0 {
+		return DefaultLibraryPanelCacheTTL
+	}
+	return c.libraryTTL
+}
+
+// cacheLibraryElements caches copies of the elements, so that callers changing the
+// models they were given do not change the cache.
+func (c *Client) cacheLibraryElements(elements ...LibraryElement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.libraryElements == nil {
+		c.libraryElements = map[string]cachedLibraryElement{}
+	}
+	now := time.Now()
+	for _, element := range elements {
+		c.libraryElements[element.UID] = cachedLibraryElement{element: element.clone(), fetched: now}
+	}
+}
+
+// clone returns a copy of the element whose model shares no targets with e.
+func (e LibraryElement) clone() LibraryElement {
+	e.Model = clonePanel(e.Model)
+	return e
+}
+
+// resolveLibraryPanel returns the library panel's model for a library panel stub, and
+// other panels unchanged. The dashboard keeps the id and position of the panel, like in Grafana.
+func (c *Client) resolveLibraryPanel(ctx context.Context, panel Panel) (Panel, error) {
+	if panel.LibraryPanel == nil || panel.LibraryPanel.UID == "" {
+		return panel, nil
+	}
+
+	element, err := c.GetLibraryPanelContext(ctx, panel.LibraryPanel.UID)
+	if err != nil {
+		return panel, err
+	}
+
+	merged := clonePanel(element.Model)
+	merged.ID = panel.ID
+	merged.GridPos = panel.GridPos
+	merged.LibraryPanel = panel.LibraryPanel
+	if merged.Title == "" {
+		merged.Title = panel.Title
+	}
+	if merged.Type == "" {
+		merged.Type = element.Type
+	}
+
+	return merged, nil
+}
@@ -1,5 +1,12 @@
 package grafanadata
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
 //////////////////////////////////////////////////
 // The important parts of a Grafana dashboard json
 //////////////////////////////////////////////////
@@ -8,59 +15,239 @@ type DashboardResponse struct {
 	Dashboard Dashboard `json:"dashboard"`
 }
 
-func (d *DashboardResponse) GetPanelByID(id int) *Panel {
-	for _, panel := range d.Dashboard.Panels {
-		if panel.ID == id {
-			return &panel
-		}
-		if len(panel.Panels) > 0 {
-			for _, nestedPanel := range panel.Panels {
-				if nestedPanel.ID == id {
-					return &nestedPanel
-				}
-			}
-		}
-	}
-	return nil
-}
-
 type DashboardTime struct {
 	From string `json:"from"`
 	To   string `json:"to"`
 }
 
 type Dashboard struct {
-	ID         int           `json:"id"`
-	Panels     []Panel       `json:"panels"`
-	Time       DashboardTime `json:"time"`
-	Templating struct {
-		List []struct {
-			Name       string     `json:"name"`
-			Type       string     `json:"type"`
-			Datasource Datasource `json:"datasource"`
-			Query      any        `json:"query"`
-			Current    struct {
-				Text  string `json:"text"`
-				Value any    `json:"value"`
-			} `json:"current"`
-		} `json:"list"`
+	ID                   int           `json:"id"`
+	Panels               []Panel       `json:"panels"`
+	Time                 DashboardTime `json:"time"`
+	Timezone             string        `json:"timezone"`
+	FiscalYearStartMonth int           `json:"fiscalYearStartMonth"`
+	WeekStart            string        `json:"weekStart"`
+	Templating           struct {
+		List []TemplateVariable `json:"list"`
 	} `json:"templating"`
 }
 
+type TemplateVariable struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Datasource Datasource       `json:"datasource"`
+	Query      any              `json:"query"`
+	Current    VariableCurrent  `json:"current"`
+	Multi      bool             `json:"multi"`
+	IncludeAll bool             `json:"includeAll"`
+	AllValue   string           `json:"allValue"`
+	Options    []VariableOption `json:"options"`
+	Regex      string           `json:"regex"`
+	Sort       int              `json:"sort"`
+	Filters    []AdhocFilter    `json:"filters"` // for adhoc variables
+}
+
+type AdhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// VariableCurrent is the selection saved with the dashboard. Value is a string, or a
+// list of strings for multi-value variables.
+type VariableCurrent struct {
+	Text  string `json:"text"`
+	Value any    `json:"value"`
+}
+
+// UnmarshalJSON accepts a list of texts for multi-value variables, joining them like Grafana displays them.
+func (v *VariableCurrent) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Text  any `json:"text"`
+		Value any `json:"value"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	v.Value = raw.Value
+	switch t := raw.Text.(type) {
+	case string:
+		v.Text = t
+	case []any:
+		texts := make([]string, 0, len(t))
+		for _, text := range t {
+			texts = append(texts, fmt.Sprint(text))
+		}
+		v.Text = strings.Join(texts, " + ")
+	}
+
+	return nil
+}
+
+// Values returns the selected values.
+func (v VariableCurrent) Values() []string {
+	switch value := v.Value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, s := range value {
+			values = append(values, fmt.Sprint(s))
+		}
+		return values
+	case nil:
+		return nil
+	}
+	return []string{fmt.Sprint(v.Value)}
+}
+
+type VariableOption struct {
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+	Selected bool   `json:"selected"`
+}
+
 type Panel struct {
-	ID            int        `json:"id"`
-	Datasource    Datasource `json:"datasource"`
-	Targets       []any      `json:"targets"`
-	Title         string     `json:"title"`
-	Panels        []Panel    `json:"panels"`        // for nested panels
-	Interval      string     `json:"interval"`       // minimum query interval, e.g. "1m", "5m"
-	MaxDataPoints *int       `json:"maxDataPoints"`  // max data points for the panel query
+	ID               int               `json:"id"`
+	Type             string            `json:"type"` // e.g. "timeseries" or "row"
+	Datasource       Datasource        `json:"datasource"`
+	Targets          []any             `json:"targets"`
+	Title            string            `json:"title"`
+	Panels           []Panel           `json:"panels"`           // for nested panels
+	Interval         string            `json:"interval"`         // minimum query interval, e.g. "1m", "5m"
+	MaxDataPoints    *int              `json:"maxDataPoints"`    // max data points for the panel query
+	TimeFrom         string            `json:"timeFrom"`         // relative time override, e.g. "1h"
+	TimeShift        string            `json:"timeShift"`        // time shift, e.g. "1d"
+	HideTimeOverride bool              `json:"hideTimeOverride"` // only hides the override info, the query is unchanged
+	GridPos          GridPos           `json:"gridPos"`
+	Collapsed        bool              `json:"collapsed"`              // for rows, whether the row holds its panels
+	Repeat           string            `json:"repeat"`                 // variable the panel or row is repeated by
+	RepeatDirection  string            `json:"repeatDirection"`        // "h" (default) or "v"
+	MaxPerRow        int               `json:"maxPerRow"`              // horizontal repeats per row, defaults to 4
+	LibraryPanel     *LibraryPanelRef  `json:"libraryPanel,omitempty"` // set when the panel is a library panel
+	FieldConfig      FieldConfigSource `json:"fieldConfig"`
+	Options          map[string]any    `json:"options"` // panel type specific options, e.g. reduceOptions
+	Transformations  []Transformation  `json:"transformations"`
+}
+
+// FieldConfigSource is the field configuration of a panel: defaults applied to every
+// field and overrides applied to the fields their matcher selects.
+type FieldConfigSource struct {
+	Defaults  FieldConfig     `json:"defaults"`
+	Overrides []FieldOverride `json:"overrides"`
+}
+
+// FieldConfig is the configuration of a field. Datasources may set it on the fields of
+// a frame too, e.g. displayNameFromDS for the legend of Prometheus queries.
+type FieldConfig struct {
+	DisplayName       string            `json:"displayName,omitempty"`       // may reference ${__field.labels.x} and ${__series.name}
+	DisplayNameFromDS string            `json:"displayNameFromDS,omitempty"` // name set by the datasource
+	Unit              string            `json:"unit,omitempty"`              // e.g. "bytes", "percentunit" or "suffix:rpm"
+	Decimals          *int              `json:"decimals,omitempty"`          // nil picks the decimals by the size of the value
+	Min               *float64          `json:"min,omitempty"`
+	Max               *float64          `json:"max,omitempty"`
+	Thresholds        *ThresholdsConfig `json:"thresholds,omitempty"`
+	Mappings          []ValueMapping    `json:"mappings,omitempty"`
+	Color             *FieldColor       `json:"color,omitempty"`
+	NoValue           string            `json:"noValue,omitempty"` // text shown for null values
+	Custom            map[string]any    `json:"custom,omitempty"`  // panel type specific settings
+}
+
+// Threshold modes.
+const (
+	ThresholdsAbsolute   = "absolute"
+	ThresholdsPercentage = "percentage" // step values are percentages between min and max
+)
+
+// ThresholdsConfig are the steps that color a value. The first step has no value and
+// applies below all others.
+type ThresholdsConfig struct {
+	Mode  string      `json:"mode"`
+	Steps []Threshold `json:"steps"`
+}
+
+// Threshold is a step of a thresholds config.
+type Threshold struct {
+	Value *float64 `json:"value"` // nil for the base step
+	Color string   `json:"color"` // e.g. "green" or "#FF0000"
+}
+
+// Value mapping types.
+const (
+	MappingValue   = "value"
+	MappingRange   = "range"
+	MappingRegex   = "regex"
+	MappingSpecial = "special"
+)
+
+// ValueMapping maps values to a text and color. Older dashboards store mappings in a
+// flat format, which is converted when decoding.
+type ValueMapping struct {
+	Type    string                        // MappingValue, MappingRange, MappingRegex or MappingSpecial
+	Values  map[string]ValueMappingResult // results by value of value mappings
+	From    *float64                      // lower bound of range mappings, nil is unbounded
+	To      *float64                      // upper bound of range mappings, nil is unbounded
+	Pattern string                        // pattern of regex mappings
+	Match   string                        // special mappings: "null", "nan", "null+nan", "true", "false" or "empty"
+	Result  ValueMappingResult            // result of range, regex and special mappings
+}
+
+// ValueMappingResult is what a mapped value is displayed as.
+type ValueMappingResult struct {
+	Text  string `json:"text,omitempty"` // empty keeps the formatted value
+	Color string `json:"color,omitempty"`
+	Index int    `json:"index"`
+}
+
+// FieldColor is the color scheme of a field.
+type FieldColor struct {
+	Mode       string `json:"mode"`                 // e.g. "thresholds", "fixed" or "palette-classic"
+	FixedColor string `json:"fixedColor,omitempty"` // for the fixed mode
+	SeriesBy   string `json:"seriesBy,omitempty"`   // "last", "min" or "max" for gradient modes
+}
+
+// FieldOverride changes the config of the fields its matcher selects.
+type FieldOverride struct {
+	Matcher    FieldMatcher    `json:"matcher"`
+	Properties []FieldProperty `json:"properties"`
+}
+
+// FieldMatcher selects fields, e.g. {"id": "byName", "options": "Value"}.
+type FieldMatcher struct {
+	ID      string `json:"id"`
+	Options any    `json:"options"`
+}
+
+// FieldProperty is a single config value set by an override, e.g. {"id": "displayName", "value": "CPU"}.
+type FieldProperty struct {
+	ID    string `json:"id"`
+	Value any    `json:"value"`
+}
+
+// LibraryPanelRef is the reference a dashboard stores for a library panel.
+type LibraryPanelRef struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+// GridPos is the position of a panel on the dashboard grid, which is 24 columns wide.
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
 type Datasource struct {
-	Type      string `json:"type"`
-	UID       string `json:"uid"`
-	IsDefault bool   `json:"isDefault,omitempty"`
+	ID        int            `json:"id,omitempty"`
+	Type      string         `json:"type"`
+	UID       string         `json:"uid"`
+	Name      string         `json:"name,omitempty"`
+	URL       string         `json:"url,omitempty"`
+	Access    string         `json:"access,omitempty"` // "proxy" or "direct"
+	IsDefault bool           `json:"isDefault,omitempty"`
+	JSONData  map[string]any `json:"jsonData,omitempty"`
 }
 
 type Target struct {
@@ -90,14 +277,48 @@ type Query struct {
 ////////////////////////////////////////////////////////
 
 type Results struct {
-	Results map[string]Result `json:"results"`
-	Legends map[string]string `json:"-"`
-	c       *Client           // reference to the client to fetch legends
+	Results       map[string]Result        `json:"results"`
+	Legends       map[string]string        `json:"-"`
+	FieldConfig   FieldConfigSource        `json:"-"` // field config of the panel, used for display names
+	ReduceOptions ReduceOptions            `json:"-"` // reduce options of stat, gauge, bar gauge and table panels
+	Targets       map[string]TargetOptions `json:"-"` // options of the queried targets by refId
+	Range         TimeRange                `json:"-"` // the absolute time range that was queried
+	TimeInfo      string                   `json:"-"` // panel time override as shown in the panel header
+	c             *Client                  // reference to the client to fetch legends
 }
 
 type Result struct {
-	Status int     `json:"status"`
-	Frames []Frame `json:"frames"`
+	Status      int     `json:"status"`
+	Error       string  `json:"error,omitempty"`
+	ErrorSource string  `json:"errorSource,omitempty"` // "plugin" or "downstream"
+	Frames      []Frame `json:"frames"`
+}
+
+// Errors returns the failed queries of the response ordered by refId.
+func (r Results) Errors() []QueryError {
+	var errs []QueryError
+	for ref, result := range r.Results {
+		if result.Error == "" && result.Status < 300 {
+			continue
+		}
+		errs = append(errs, QueryError{
+			RefID:   ref,
+			Status:  result.Status,
+			Message: result.Error,
+			Source:  result.ErrorSource,
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].RefID < errs[j].RefID })
+	return errs
+}
+
+// Err returns a *PartialResultError listing the failed queries, or nil if every query succeeded.
+func (r Results) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	return &PartialResultError{Errors: errs}
 }
 
 type Frame struct {
@@ -106,6 +327,7 @@ type Frame struct {
 }
 
 type Schema struct {
+	Name   string                 `json:"name,omitempty"`
 	RefId  string                 `json:"refId"`
 	Meta   map[string]interface{} `json:"meta"`
 	Fields []Field                `json:"fields"`
@@ -119,8 +341,9 @@ type Field struct {
 	Labels   map[string]string      `json:"labels,omitempty"`
 }
 
+// Data holds one typed column per schema field.
 type Data struct {
-	Values [][]float64 `json:"values"`
+	Values []Column `json:"values"`
 }
 
 /////////////////////////////////////////////////
@@ -137,9 +360,12 @@ type PrometheusMetricData struct {
 	Result     []PrometheusMetricDataResult `json:"result"`
 }
 
+// PrometheusMetricDataResult is a series of a matrix, which has Values, or a sample of
+// a vector or scalar result, which has a Value.
 type PrometheusMetricDataResult struct {
 	Metric map[string]string `json:"metric"`
-	Values [][]interface{}   `json:"values"`
+	Values [][]interface{}   `json:"values,omitempty"`
+	Value  []interface{}     `json:"value,omitempty"`
 }
 
 type PrometheusValues struct {
@@ -168,6 +394,8 @@ type DashboardSearch struct {
 }
 
 type PanelSearch struct {
-	ID    int    `json:"id"`
-	Title string `json:"title"`
+	ID    int      `json:"id"`
+	Title string   `json:"title"`
+	Type  string   `json:"type,omitempty"`
+	Path  []string `json:"path,omitempty"` // titles of the containing rows and panels
 }
@@ -0,0 +1,67 @@
+package grafanadata
+
+// PanelRef is a panel found while walking a dashboard together with the titles of
+// the rows and parent panels that contain it, outermost first.
+type PanelRef struct {
+	Panel *Panel
+	Path  []string
+}
+
+// WalkPanels calls fn for every panel of the dashboard, descending into rows and
+// nested panels to any depth. Parents are visited before their children. Walking
+// stops when fn returns false.
+func (d *DashboardResponse) WalkPanels(fn func(panel *Panel, path []string) bool) {
+	walkPanels(d.Dashboard.Panels, nil, fn)
+}
+
+func walkPanels(panels []Panel, path []string, fn func(panel *Panel, path []string) bool) bool {
+	for i := range panels {
+		p := &panels[i]
+		if !fn(p, path) {
+			return false
+		}
+		if len(p.Panels) == 0 {
+			continue
+		}
+		// copy the path so that callers may keep it
+		nested := append(append([]string(nil), path...), p.Title)
+		if !walkPanels(p.Panels, nested, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllPanels returns every panel of the dashboard in walk order.
+func (d *DashboardResponse) AllPanels() []PanelRef {
+	var refs []PanelRef
+	d.WalkPanels(func(panel *Panel, path []string) bool {
+		refs = append(refs, PanelRef{Panel: panel, Path: path})
+		return true
+	})
+	return refs
+}
+
+// findPanel returns the first panel in walk order that matches.
+func (d *DashboardResponse) findPanel(match func(panel *Panel) bool) *Panel {
+	var found *Panel
+	d.WalkPanels(func(panel *Panel, _ []string) bool {
+		if match(panel) {
+			found = panel
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// GetPanelByID returns the panel with the given id, searching rows and nested panels.
+func (d *DashboardResponse) GetPanelByID(id int) *Panel {
+	return d.findPanel(func(panel *Panel) bool { return panel.ID == id })
+}
+
+// GetPanelByTitle returns the first panel with the given title, searching rows and
+// nested panels.
+func (d *DashboardResponse) GetPanelByTitle(title string) *Panel {
+	return d.findPanel(func(panel *Panel) bool { return panel.Title == title })
+}
@@ -1,55 +1,383 @@
 package grafanadata
 
-import "strings"
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
 
-// ConvertResultToPrometheusFormat converts a Grafana data response into prometheus format
+// ConvertResultToPrometheusFormat converts a Grafana data response into prometheus format.
+// Every numeric field of a frame becomes a series, named like the Grafana legend in its
+// __legend__ label. Frames of targets with the table
+// format take their labels from the string columns of each row, see splitFrame, and the cumulative
+// buckets of targets with the heatmap format are converted to per bucket counts like
+// Grafana's heatmap does.
 func ConvertResultToPrometheusFormat(results Results) PrometheusMetricResponse {
 	promResponse := PrometheusMetricResponse{
 		Status: "success",
 		Data: PrometheusMetricData{
-			ResultType: "matrix",
+			ResultType: promMatrix,
 		},
 	}
 
-	for ref, result := range results.Results {
-		for _, frame := range result.Frames {
-			var promResult PrometheusMetricDataResult
+	// series without timestamps, such as reduced expressions, are sampled at the end of the range
+	var at float64
+	if !results.Range.To.IsZero() {
+		at = float64(results.Range.To.UnixMilli())
+	}
+
+	names := newDisplayNamer(results)
+	var resultTypes []string
+	refs := make([]string, 0, len(results.Results))
+	for ref := range results.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
 
-			metricLabels := map[string]string{
-				"__refId__": ref,
+	for _, ref := range refs {
+		target, ok := results.Targets[ref]
+		if !ok {
+			target.Format = FormatTimeSeries
+		}
+
+		var series []PrometheusMetricDataResult
+		for _, frame := range results.Results[ref].Frames {
+			resultType := frameResultType(frame, target)
+			for _, s := range splitFrame(ref, frame, target.Format == FormatTable, names) {
+				series = append(series, s.promResult(at))
+				resultTypes = append(resultTypes, resultType)
 			}
+		}
+		if target.Format == FormatHeatmap {
+			series = deaccumulateBuckets(series)
+		}
 
-			legend := results.Legends[ref]
+		promResponse.Data.Result = append(promResponse.Data.Result, series...)
+	}
 
-			for _, field := range frame.Schema.Fields {
-				for labelKey, labelValue := range field.Labels {
-					metricLabels[labelKey] = labelValue
-					if legend != "" {
-						legend = strings.ReplaceAll(legend, "{{"+labelKey+"}}", labelValue)
-					}
-				}
+	promResponse.Data.ResultType = combinedResultType(resultTypes)
+	if promResponse.Data.ResultType != promMatrix {
+		// instant results hold their last sample only
+		for i, r := range promResponse.Data.Result {
+			if len(r.Values) > 0 {
+				promResponse.Data.Result[i].Value = r.Values[len(r.Values)-1]
 			}
+			promResponse.Data.Result[i].Values = nil
+		}
+	}
 
-			if legend != "" {
-				metricLabels["__legend__"] = legend
+	return promResponse
+}
+
+// Prometheus result types.
+const (
+	promMatrix = "matrix"
+	promVector = "vector"
+	promScalar = "scalar"
+)
+
+// frameResultType returns the prometheus result type of a frame: the result type
+// reported by the Prometheus datasource, else the frame type, else the instant option
+// of the target. Frames with a single unlabelled number and no time are scalars.
+func frameResultType(frame Frame, target TargetOptions) string {
+	meta := frame.Schema.Meta
+	if custom, ok := meta["custom"].(map[string]interface{}); ok {
+		switch custom["resultType"] {
+		case promMatrix:
+			return promMatrix
+		case promVector:
+			return promVector
+		case promScalar:
+			return promScalar
+		}
+	}
+
+	if frameType, ok := meta["type"].(string); ok {
+		switch {
+		case strings.HasPrefix(frameType, "numeric-"):
+			return promVector
+		case strings.HasPrefix(frameType, "timeseries-"):
+			return promMatrix
+		}
+	}
+
+	if timeColumn(frame) < 0 {
+		var numeric []int
+		for i, col := range frame.Data.Values {
+			if isNumeric(col) {
+				numeric = append(numeric, i)
 			}
+		}
+		if len(numeric) == 1 && frame.Data.Values[numeric[0]].Len() == 1 {
+			i := numeric[0]
+			if i >= len(frame.Schema.Fields) || len(frame.Schema.Fields[i].Labels) == 0 {
+				return promScalar
+			}
+		}
+		return promVector
+	}
+
+	if target.Instant && !target.Range {
+		return promVector
+	}
+	return promMatrix
+}
+
+// combinedResultType returns the result type of a response holding series of the
+// given types. A single scalar stays a scalar, instant results form a vector and
+// anything else is returned as a matrix.
+func combinedResultType(types []string) string {
+	if len(types) == 0 {
+		return promMatrix
+	}
+	if len(types) == 1 && types[0] == promScalar {
+		return promScalar
+	}
+	for _, t := range types {
+		if t == promMatrix {
+			return promMatrix
+		}
+	}
+	return promVector
+}
+
+// MarshalJSON encodes scalar results as a single [timestamp, value] pair like prometheus.
+func (d PrometheusMetricData) MarshalJSON() ([]byte, error) {
+	type plain PrometheusMetricData
+	if d.ResultType == promScalar && len(d.Result) == 1 {
+		return json.Marshal(struct {
+			ResultType string        `json:"resultType"`
+			Result     []interface{} `json:"result"`
+		}{d.ResultType, d.Result[0].Value})
+	}
+	return json.Marshal(plain(d))
+}
+
+// UnmarshalJSON decodes prometheus data, including scalar results.
+func (d *PrometheusMetricData) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	d.ResultType = raw.ResultType
+	d.Result = nil
+	if len(raw.Result) == 0 || string(raw.Result) == "null" {
+		return nil
+	}
+	if raw.ResultType == promScalar {
+		var value []interface{}
+		if err := json.Unmarshal(raw.Result, &value); err != nil {
+			return err
+		}
+		d.Result = []PrometheusMetricDataResult{{Metric: map[string]string{}, Value: value}}
+		return nil
+	}
+	return json.Unmarshal(raw.Result, &d.Result)
+}
+
+// timeColumn returns the index of the first time field of a frame, or -1.
+func timeColumn(frame Frame) int {
+	for i, col := range frame.Data.Values {
+		if col.Kind == KindTime {
+			return i
+		}
+	}
+	if len(frame.Schema.Fields) == 0 && len(frame.Data.Values) >= 2 {
+		// frames without schema store time as numbers in the first column
+		return 0
+	}
+	return -1
+}
 
-			promResult.Metric = metricLabels
-			if len(frame.Data.Values) >= 2 {
-				timestamps := frame.Data.Values[0]
-				values := frame.Data.Values[1]
+func isNumeric(col Column) bool {
+	return col.Kind == KindFloat64 || col.Kind == KindInt64
+}
 
-				for index, timestamp := range timestamps {
-					if index < len(values) {
-						value := values[index]
-						promResult.Values = append(promResult.Values, []interface{}{timestamp / 1000, value})
+// frameSeries is a series of a frame: the rows of a numeric field.
+type frameSeries struct {
+	ref    string
+	name   string            // display name of the series
+	labels map[string]string // labels of the field, or of the rows of table frames
+	field  string            // name of the field when the labels do not tell the series apart
+	times  Column            // the time field, empty when the frame has none
+	values Column
+	rows   []int // rows with a timestamp, or the last row of frames without time
+}
+
+// splitFrame splits a frame into series, one per numeric field. The rows of table
+// frames, as returned for targets with the table format, are labelled by their string
+// fields instead, and the rows with the same labels form a series per numeric field.
+// Every series is named by names, like the field it would be in a time series frame.
+func splitFrame(ref string, frame Frame, table bool, names *displayNamer) []frameSeries {
+	ts := timeColumn(frame)
+	if table && ts < 0 {
+		return nil
+	}
+	var times Column
+	if ts >= 0 {
+		times = frame.Data.Values[ts]
+	}
+
+	var labelFields, valueFields []int
+	for i, col := range frame.Data.Values {
+		switch {
+		case i == ts:
+		case table && col.Kind == KindString:
+			labelFields = append(labelFields, i)
+		case isNumeric(col):
+			valueFields = append(valueFields, i)
+		}
+	}
+
+	var series []frameSeries
+	if !table {
+		for _, i := range valueFields {
+			field := frameField(frame, i)
+			s := frameSeries{ref: ref, name: names.name(ref, frame, i), labels: field.Labels, times: times, values: frame.Data.Values[i]}
+			if len(valueFields) > 1 && len(field.Labels) == 0 {
+				s.field = field.Name
+			}
+			if ts < 0 {
+				if n := s.values.Len(); n > 0 {
+					s.rows = []int{n - 1}
+				}
+			} else {
+				for row := 0; row < times.Len() && row < s.values.Len(); row++ {
+					if !times.IsNull(row) {
+						s.rows = append(s.rows, row)
 					}
 				}
 			}
+			series = append(series, s)
+		}
+		return series
+	}
 
-			promResponse.Data.Result = append(promResponse.Data.Result, promResult)
+	// name the rows of a table like a field holding their labels, fields without
+	// schema by their index
+	named := frame
+	named.Schema.Fields = make([]Field, len(frame.Data.Values))
+	for i := range named.Schema.Fields {
+		named.Schema.Fields[i] = frameField(frame, i)
+		if named.Schema.Fields[i].Name == "" {
+			named.Schema.Fields[i].Name = strconv.Itoa(i)
 		}
 	}
 
-	return promResponse
+	index := map[string]int{}
+	for row := 0; row < times.Len(); row++ {
+		if times.IsNull(row) {
+			continue
+		}
+
+		for _, vf := range valueFields {
+			field := frameField(named, vf)
+			labels := map[string]string{}
+			key := field.Name
+			for _, lf := range labelFields {
+				if v, ok := frame.Data.Values[lf].Value(row).(string); ok {
+					name := frameField(named, lf).Name
+					labels[name] = v
+					key += "\x00" + name + "=" + v
+				}
+			}
+
+			i, ok := index[key]
+			if !ok {
+				i = len(series)
+				index[key] = i
+				field.Labels = labels
+				named.Schema.Fields[vf] = field
+				s := frameSeries{ref: ref, name: names.name(ref, named, vf), labels: labels, times: times, values: frame.Data.Values[vf]}
+				if len(valueFields) > 1 {
+					s.field = field.Name
+				}
+				series = append(series, s)
+			}
+			series[i].rows = append(series[i].rows, row)
+		}
+	}
+
+	return series
+}
+
+// promResult converts a series. Series of frames without a time field, like the
+// output of reduce expressions, give a single sample at the timestamp at, in
+// milliseconds like the time fields.
+func (s frameSeries) promResult(at float64) PrometheusMetricDataResult {
+	metric := map[string]string{"__refId__": s.ref}
+	for key, value := range s.labels {
+		metric[key] = value
+	}
+	if s.field != "" {
+		metric["__field__"] = s.field
+	}
+	metric["__legend__"] = s.name
+
+	result := PrometheusMetricDataResult{Metric: metric}
+	for _, row := range s.rows {
+		timestamp := at
+		if s.times.Len() > 0 {
+			var ok bool
+			if timestamp, ok = s.times.Float64(row); !ok {
+				continue
+			}
+		}
+		// prometheus has no null samples, gaps are simply missing
+		value, ok := s.values.Float64(row)
+		if !ok {
+			continue
+		}
+		result.Values = append(result.Values, []interface{}{timestamp / 1000, promValue(value)})
+	}
+	return result
+}
+
+// deaccumulateBuckets sorts histogram series by their "le" label and subtracts the
+// count of the previous bucket, turning cumulative buckets into per bucket counts.
+func deaccumulateBuckets(series []PrometheusMetricDataResult) []PrometheusMetricDataResult {
+	le := func(s PrometheusMetricDataResult) float64 {
+		v, err := strconv.ParseFloat(s.Metric["le"], 64)
+		if err != nil {
+			return math.Inf(1)
+		}
+		return v
+	}
+	sort.SliceStable(series, func(i, j int) bool { return le(series[i]) < le(series[j]) })
+
+	for i := len(series) - 1; i > 0; i-- {
+		previous := map[interface{}]float64{}
+		for _, point := range series[i-1].Values {
+			if v, ok := point[1].(float64); ok {
+				previous[point[0]] = v
+			}
+		}
+		for _, point := range series[i].Values {
+			if v, ok := point[1].(float64); ok {
+				point[1] = v - previous[point[0]]
+			}
+		}
+	}
+
+	return series
+}
+
+// promValue returns the sample value, using prometheus' string encoding for
+// NaN and infinities which cannot be represented as JSON numbers.
+func promValue(v float64) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return v
 }
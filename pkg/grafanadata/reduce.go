@@ -0,0 +1,426 @@
+package grafanadata
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// standardReducers are the calcs computed in a single pass by Grafana's doStandardCalcs.
+var standardReducers = map[string]bool{
+	"sum": true, "max": true, "min": true, "logmin": true, "mean": true,
+	"last": true, "first": true, "lastNotNull": true, "firstNotNull": true,
+	"count": true, "allIsNull": true, "allIsZero": true,
+	"range": true, "diff": true, "delta": true, "step": true, "diffperc": true,
+}
+
+// percentileRegex matches the percentile reducers p1 to p99.
+var percentileRegex = regexp.MustCompile(`^p([1-9][0-9]?)$`)
+
+// defaultValuesLimit is the number of rows Grafana shows when a panel shows all values.
+const defaultValuesLimit = 25
+
+// ReduceOptions select the fields of stat, gauge, bar gauge and table panels and how
+// they are reduced, as saved in the reduceOptions of the panel options.
+type ReduceOptions struct {
+	Values bool     `json:"values"` // show every row instead of reducing
+	Limit  int      `json:"limit"`  // rows shown when Values is set, defaults to 25
+	Calcs  []string `json:"calcs"`  // reducers, defaults to lastNotNull
+	Fields string   `json:"fields"` // "" for the numeric fields, "/.*/" for all, else a display name or /regex/
+}
+
+// StatValue is a single value a stat panel shows.
+type StatValue struct {
+	RefID   string
+	Field   string // name of the field
+	Name    string // display name of the field
+	Labels  map[string]string
+	Calc    string // the reducer, empty when the panel shows all values
+	Row     int    // the row when the panel shows all values
+	Value   any    // see Reduce
+	Display DisplayValue
+}
+
+// panelReduceOptions returns the reduce options of a panel.
+func panelReduceOptions(panel Panel) ReduceOptions {
+	var opts ReduceOptions
+	if raw, ok := panel.Options["reduceOptions"]; ok {
+		decodeProperty(raw, &opts)
+	}
+	return opts
+}
+
+// StatValues returns the values the panel shows when it is a stat, gauge, bar gauge or
+// table panel, using the reduce options of the panel.
+func (r Results) StatValues() ([]StatValue, error) {
+	return r.ReduceFields(r.ReduceOptions)
+}
+
+// ReduceFields returns a value per calc for every field the options select, ordered
+// by refId, or the rows of the fields when the options show all values. Time fields
+// are never selected. Values are rendered with the field config of their field.
+func (r Results) ReduceFields(opts ReduceOptions) ([]StatValue, error) {
+	calcs := opts.Calcs
+	if len(calcs) == 0 {
+		calcs = []string{"lastNotNull"}
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultValuesLimit
+	}
+
+	var fieldsRegex *regexp.Regexp
+	if len(opts.Fields) > 1 && strings.HasPrefix(opts.Fields, "/") {
+		re, err := compilePattern(opts.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fields %v with error %w", opts.Fields, err)
+		}
+		fieldsRegex = re
+	}
+
+	refs := make([]string, 0, len(r.Results))
+	for ref := range r.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	names := newDisplayNamer(r)
+	var values []StatValue
+	for _, ref := range refs {
+		for _, frame := range r.Results[ref].Frames {
+			for i, col := range frame.Data.Values {
+				if col.Kind == KindTime {
+					continue
+				}
+
+				field := frameField(frame, i)
+				name := names.name(ref, frame, i)
+				switch {
+				case fieldsRegex != nil:
+					if !fieldsRegex.MatchString(name) {
+						continue
+					}
+				case opts.Fields == "":
+					if !isNumeric(col) {
+						continue
+					}
+				case opts.Fields != name && opts.Fields != field.Name:
+					continue
+				}
+
+				config := names.fieldConfig(ref, frame, i)
+				value := StatValue{RefID: ref, Field: field.Name, Name: name, Labels: field.Labels}
+
+				if opts.Values {
+					for row := 0; row < col.Len() && len(values) < limit; row++ {
+						value.Row = row
+						value.Value = reduceValue(col, row)
+						value.Display = config.Display(value.Value)
+						values = append(values, value)
+					}
+					continue
+				}
+
+				reduced, err := Reduce(col, calcs...)
+				if err != nil {
+					return nil, err
+				}
+				for _, calc := range calcs {
+					value.Calc = calc
+					value.Value = reduced[calc]
+					value.Display = config.Display(value.Value)
+					values = append(values, value)
+				}
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// Reduce computes Grafana's reducers, the calcs of reduceOptions, over a column:
+// lastNotNull, last, firstNotNull, first, mean, min, max, sum, count, range, delta,
+// step, diff, diffperc, logmin, allIsZero, allIsNull, changeCount, distinctCount,
+// variance, stdDev, median, allValues, uniqueValues and the percentiles p1 to p99.
+//
+// Like Grafana, nulls are counted by count but skipped by the numeric reducers, and a
+// NaN makes sum and mean NaN. Numbers and times, as epoch milliseconds, are float64,
+// allIsZero and allIsNull are bool, allValues and uniqueValues are []any, and reducers
+// Grafana gives no value, like the max of a column of nulls, are nil. first and last
+// of string columns are the strings.
+func Reduce(col Column, calcs ...string) (map[string]any, error) {
+	reduced := make(map[string]any, len(calcs))
+	var standard map[string]any
+	for _, calc := range calcs {
+		switch {
+		case standardReducers[calc]:
+			if standard == nil {
+				standard = standardCalcs(col)
+			}
+			reduced[calc] = standard[calc]
+		case calc == "changeCount":
+			reduced[calc] = changeCount(col)
+		case calc == "distinctCount":
+			reduced[calc] = float64(len(uniqueValues(col)))
+		case calc == "variance" || calc == "stdDev":
+			variance := variance(col)
+			if calc == "stdDev" {
+				reduced[calc] = math.Sqrt(variance)
+			} else {
+				reduced[calc] = variance
+			}
+		case calc == "median":
+			reduced[calc] = median(col)
+		case calc == "allValues":
+			all := make([]any, col.Len())
+			for i := range all {
+				all[i] = reduceValue(col, i)
+			}
+			reduced[calc] = all
+		case calc == "uniqueValues":
+			reduced[calc] = uniqueValues(col)
+		case percentileRegex.MatchString(calc):
+			p, _ := strconv.Atoi(calc[1:])
+			reduced[calc] = percentile(col, float64(p)/100)
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrUnknownReducer, calc)
+		}
+	}
+	return reduced, nil
+}
+
+// reduceValue returns row i as reducers see it: numbers and times as float64, JSON
+// as string, other values as they are, nil for nulls.
+func reduceValue(col Column, i int) any {
+	if isNumeric(col) || col.Kind == KindTime {
+		if f, ok := col.Float64(i); ok {
+			return f
+		}
+		return nil
+	}
+	if col.Kind == KindJSON && !col.IsNull(i) && i < len(col.JSON) {
+		return string(col.JSON[i])
+	}
+	return col.Value(i)
+}
+
+// standardCalcs is Grafana's doStandardCalcs.
+func standardCalcs(col Column) map[string]any {
+	numeric := isNumeric(col) || col.Kind == KindTime
+
+	var (
+		sum, delta                             float64
+		max, min, logmin                       = -math.MaxFloat64, math.MaxFloat64, math.MaxFloat64
+		step                                   = math.MaxFloat64
+		first, last, firstNotNull, lastNotNull any
+		count, nonNull                         int
+		allIsNull, allIsZero                   = true, true
+		previousDeltaUp                        = true
+	)
+
+	n := col.Len()
+	for i := 0; i < n; i++ {
+		v := reduceValue(col, i)
+		if i == 0 {
+			first = v
+		}
+		last = v
+		count++
+		if v == nil {
+			continue
+		}
+
+		isFirst := firstNotNull == nil
+		if isFirst {
+			firstNotNull = v
+		}
+		if numeric {
+			f := v.(float64)
+			sum += f
+			allIsNull = false
+			nonNull++
+			if !isFirst {
+				previous := lastNotNull.(float64)
+				if f-previous < step {
+					step = f - previous
+				}
+				if previous > f {
+					// counter reset
+					previousDeltaUp = false
+					if i == n-1 {
+						delta += f
+					}
+				} else {
+					if previousDeltaUp {
+						delta += f - previous
+					} else {
+						delta += f
+					}
+					previousDeltaUp = true
+				}
+			}
+			if f > max {
+				max = f
+			}
+			if f < min {
+				min = f
+			}
+			if f < logmin && f > 0 {
+				logmin = f
+			}
+		}
+		if v != 0.0 {
+			allIsZero = false
+		}
+		lastNotNull = v
+	}
+
+	calcs := map[string]any{
+		"sum":          sum,
+		"max":          nil,
+		"min":          nil,
+		"logmin":       nil,
+		"mean":         nil,
+		"first":        first,
+		"last":         last,
+		"firstNotNull": firstNotNull,
+		"lastNotNull":  lastNotNull,
+		"count":        float64(count),
+		"allIsNull":    allIsNull,
+		"allIsZero":    allIsZero && !allIsNull,
+		"range":        nil,
+		"diff":         nil,
+		"delta":        delta,
+		"step":         nil,
+		"diffperc":     0.0,
+	}
+	if max != -math.MaxFloat64 {
+		calcs["max"] = max
+	}
+	if min != math.MaxFloat64 {
+		calcs["min"] = min
+	}
+	if logmin != math.MaxFloat64 {
+		calcs["logmin"] = logmin
+	}
+	if step != math.MaxFloat64 {
+		calcs["step"] = step
+	}
+	if nonNull > 0 {
+		calcs["mean"] = sum / float64(nonNull)
+	}
+	if calcs["max"] != nil && calcs["min"] != nil {
+		calcs["range"] = max - min
+	}
+	firstNumber, ok1 := firstNotNull.(float64)
+	lastNumber, ok2 := lastNotNull.(float64)
+	if ok1 && ok2 {
+		diff := lastNumber - firstNumber
+		calcs["diff"] = diff
+		calcs["diffperc"] = diff / firstNumber
+	}
+
+	return calcs
+}
+
+// changeCount counts how often the value differs from the previous row.
+func changeCount(col Column) float64 {
+	count := 0
+	var last any
+	for i := 0; i < col.Len(); i++ {
+		v := reduceValue(col, i)
+		if i > 0 && !sameValue(v, last) {
+			count++
+		}
+		last = v
+	}
+	return float64(count)
+}
+
+// sameValue compares values like javascript's ===, for which NaN differs from itself.
+func sameValue(a, b any) bool {
+	if f, ok := a.(float64); ok && math.IsNaN(f) {
+		return false
+	}
+	return a == b
+}
+
+// uniqueValues returns the distinct values in order of appearance. Like a javascript
+// Set, null is a value and NaN equals itself.
+func uniqueValues(col Column) []any {
+	seen := map[any]bool{}
+	seenNaN := false
+	var unique []any
+	for i := 0; i < col.Len(); i++ {
+		v := reduceValue(col, i)
+		if f, ok := v.(float64); ok && math.IsNaN(f) {
+			if !seenNaN {
+				seenNaN = true
+				unique = append(unique, v)
+			}
+			continue
+		}
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// variance is the population variance of the non-null numbers, 0 without numbers.
+func variance(col Column) float64 {
+	var squareSum, mean float64
+	count := 0
+	for i := 0; i < col.Len(); i++ {
+		f, ok := reduceValue(col, i).(float64)
+		if !ok {
+			continue
+		}
+		count++
+		previous := mean
+		mean += (f - previous) / float64(count)
+		squareSum += (f - previous) * (f - mean)
+	}
+	if count == 0 {
+		return 0
+	}
+	return squareSum / float64(count)
+}
+
+// sortedNumbers returns the non-null numbers of a column in ascending order.
+func sortedNumbers(col Column) []float64 {
+	var numbers []float64
+	for i := 0; i < col.Len(); i++ {
+		if f, ok := reduceValue(col, i).(float64); ok && !math.IsNaN(f) {
+			numbers = append(numbers, f)
+		}
+	}
+	sort.Float64s(numbers)
+	return numbers
+}
+
+// median returns the middle number, or the mean of the two middle numbers.
+func median(col Column) any {
+	numbers := sortedNumbers(col)
+	if len(numbers) == 0 {
+		return nil
+	}
+	mid := len(numbers) / 2
+	if len(numbers)%2 != 0 {
+		return numbers[mid]
+	}
+	return (numbers[mid-1] + numbers[mid]) / 2
+}
+
+// percentile returns the number of the nearest rank.
+func percentile(col Column, p float64) any {
+	numbers := sortedNumbers(col)
+	if len(numbers) == 0 {
+		return nil
+	}
+	return numbers[int(math.Floor(float64(len(numbers)-1)*p+0.5))]
+}
@@ -0,0 +1,344 @@
+package grafanadata
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// gridColumns is the width of Grafana's dashboard grid.
+const gridColumns = 24
+
+// defaultMaxPerRow is the number of horizontal repeats per row Grafana uses when
+// maxPerRow is not set.
+const defaultMaxPerRow = 4
+
+// RepeatedPanel is a concrete panel produced by expanding panel and row repeats.
+// Copies keep the ID of the panel they were repeated from and are positioned like
+// Grafana lays them out. Panels that do not repeat are returned once.
+type RepeatedPanel struct {
+	Panel      Panel
+	Path       []string          // titles of the containing rows and panels
+	ScopedVars map[string]string // repeat variable values of this copy, e.g. {"instance": "a:1"}
+}
+
+// RepeatedResults is the data of a single repeated copy of a panel.
+type RepeatedResults struct {
+	RepeatedPanel
+	Results Results
+}
+
+// ExpandRepeats expands the repeated panels and rows of a dashboard into one panel per
+// selected value of their repeat variable. Row panels themselves are not returned.
+func (c *Client) ExpandRepeats(dashboard DashboardResponse, opts ...PanelOption) ([]RepeatedPanel, error) {
+	return c.ExpandRepeatsContext(context.Background(), dashboard, opts...)
+}
+
+// ExpandRepeatsContext expands the repeated panels and rows of a dashboard using the provided context.
+func (c *Client) ExpandRepeatsContext(ctx context.Context, dashboard DashboardResponse, opts ...PanelOption) ([]RepeatedPanel, error) {
+	return c.expandRepeats(ctx, dashboard, 0, opts...)
+}
+
+// expandRepeats expands the repeats of a dashboard, only those of the panel with the
+// given id and of the panels and rows containing it unless id is 0.
+func (c *Client) expandRepeats(ctx context.Context, dashboard DashboardResponse, id int, opts ...PanelOption) ([]RepeatedPanel, error) {
+	e := &repeatExpander{
+		c:       c,
+		ctx:     ctx,
+		list:    dashboard.Dashboard.Templating.List,
+		options: newPanelOptions(opts...),
+		only:    id,
+	}
+
+	panels := dashboard.Dashboard.Panels
+	for i := 0; i < len(panels); i++ {
+		p := panels[i]
+		if p.Type != "row" {
+			if err := e.expandPanel(p, nil, nil, 0); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// the panels of an expanded row follow it until the next row
+		children := p.Panels
+		if !p.Collapsed {
+			children = nil
+			for i+1 < len(panels) && panels[i+1].Type != "row" {
+				i++
+				children = append(children, panels[i])
+			}
+		}
+		if err := e.expandRow(p, children); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.panels, nil
+}
+
+// GetRepeatedPanelData retrieves the data of every repeated copy of a panel.
+func (c *Client) GetRepeatedPanelData(uid string, panelID int, opts ...PanelOption) ([]RepeatedResults, error) {
+	return c.GetRepeatedPanelDataContext(context.Background(), uid, panelID, opts...)
+}
+
+// GetRepeatedPanelDataContext retrieves the data of every repeated copy of a panel using the provided context.
+// Each copy is queried with its repeat variables set to the value of the copy.
+func (c *Client) GetRepeatedPanelDataContext(ctx context.Context, uid string, panelID int, opts ...PanelOption) ([]RepeatedResults, error) {
+	dashboard, err := c.getDashboard(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	panels, err := c.expandRepeats(ctx, dashboard, panelID, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RepeatedResults
+	for _, rp := range panels {
+		if rp.Panel.ID != panelID {
+			continue
+		}
+
+		scoped := make(map[string][]string, len(rp.ScopedVars))
+		for name, value := range rp.ScopedVars {
+			scoped[name] = []string{value}
+		}
+		panelOpts := append(append([]PanelOption(nil), opts...), WithMultiValueVariables(scoped))
+
+		panel := rp.Panel
+		result, err := c.queryPanel(ctx, &panel, dashboard, panelOpts...)
+		if err != nil {
+			return results, fmt.Errorf("failed to get data of panel %v with %v: %w", panelID, rp.ScopedVars, err)
+		}
+		results = append(results, RepeatedResults{RepeatedPanel: rp, Results: result})
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: %v in dashboard %v", ErrPanelNotFound, panelID, uid)
+	}
+
+	return results, nil
+}
+
+type repeatExpander struct {
+	c       *Client
+	ctx     context.Context
+	list    []TemplateVariable
+	options panelOptions
+	only    int // id of the only panel to expand, 0 for every panel
+
+	resolved *VariableSet // resolved lazily when an "All" selection has no saved options
+	panels   []RepeatedPanel
+}
+
+// wanted reports whether p is, or contains, a panel that is expanded.
+func (e *repeatExpander) wanted(p Panel) bool {
+	if e.only == 0 || p.ID == e.only {
+		return true
+	}
+	for _, child := range p.Panels {
+		if e.wanted(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandRow repeats a row and its panels, stacking the copies below each other.
+func (e *repeatExpander) expandRow(row Panel, children []Panel) error {
+	wanted := false
+	for _, p := range children {
+		wanted = wanted || e.wanted(p)
+	}
+	if !wanted {
+		return nil
+	}
+
+	values, err := e.repeatValues(row.Repeat)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		values = []string{""}
+	}
+
+	height := 1
+	for _, p := range children {
+		if bottom := p.GridPos.Y + p.GridPos.H - row.GridPos.Y; bottom > height {
+			height = bottom
+		}
+	}
+
+	for i, value := range values {
+		scoped := map[string]string{}
+		if value != "" {
+			scoped[row.Repeat] = value
+		}
+		path := []string{scopedInterpolator(scoped).Interpolate(row.Title, "")}
+		for _, p := range children {
+			if err := e.expandPanel(p, path, scoped, i*height); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expandPanel repeats a panel and recurses into its nested panels. offset moves the
+// copies down, e.g. for panels of a repeated row.
+func (e *repeatExpander) expandPanel(p Panel, path []string, scoped map[string]string, offset int) error {
+	if !e.wanted(p) {
+		return nil
+	}
+
+	// library panels keep their repeat settings in the library model
+	p, err := e.c.resolveLibraryPanel(e.ctx, p)
+	if err != nil {
+		return fmt.Errorf("failed to resolve library panel of panel %v: %w", p.ID, err)
+	}
+
+	values, err := e.repeatValues(p.Repeat)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		values = []string{""}
+	}
+
+	maxPerRow := p.MaxPerRow
+	if maxPerRow <= 0 {
+		maxPerRow = defaultMaxPerRow
+	}
+	// like Grafana, the width is a fraction of the grid, e.g. 4.8 columns for 5 values,
+	// and so are the positions; the grid positions of the copies round them down
+	width := math.Max(float64(gridColumns)/float64(len(values)), float64(gridColumns)/float64(maxPerRow))
+
+	x, y := 0.0, p.GridPos.Y
+	for i, value := range values {
+		vars := make(map[string]string, len(scoped)+1)
+		for name, v := range scoped {
+			vars[name] = v
+		}
+		if value != "" {
+			vars[p.Repeat] = value
+		}
+
+		clone := clonePanel(p)
+		clone.Title = scopedInterpolator(vars).Interpolate(p.Title, "")
+		if value != "" {
+			if p.RepeatDirection == "v" {
+				clone.GridPos.Y = p.GridPos.Y + i*p.GridPos.H
+			} else {
+				clone.GridPos.X, clone.GridPos.Y, clone.GridPos.W = int(x), y, int(width)
+				x += width
+				if x+width > gridColumns {
+					x = 0
+					y += p.GridPos.H
+				}
+			}
+		}
+		clone.GridPos.Y += offset
+
+		e.panels = append(e.panels, RepeatedPanel{Panel: clone, Path: path, ScopedVars: vars})
+
+		if len(p.Panels) > 0 {
+			nested := append(append([]string(nil), path...), clone.Title)
+			for _, child := range p.Panels {
+				if err := e.expandPanel(child, nested, vars, offset); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// repeatValues returns the values a repeat variable is expanded into: the explicit
+// values, else the saved selection. "All" expands to every option of the variable.
+func (e *repeatExpander) repeatValues(name string) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	var tpl *TemplateVariable
+	for i := range e.list {
+		if e.list[i].Name == name {
+			tpl = &e.list[i]
+		}
+	}
+	if tpl == nil {
+		e.c.log.Warn("repeat variable not found, not repeating", "variable", name)
+		return nil, nil
+	}
+
+	selected, ok := e.options.variables[name]
+	if !ok {
+		selected = tpl.Current.Values()
+	}
+	if !(VariableValue{Values: selected}).IsAll() {
+		return selected, nil
+	}
+
+	var options []string
+	for _, o := range tpl.Options {
+		if o.Value != allValue {
+			options = append(options, o.Value)
+		}
+	}
+	if len(options) > 0 {
+		return options, nil
+	}
+
+	// the options of query variables are usually not saved with the dashboard
+	if e.resolved == nil {
+		set, err := e.c.resolveVariableSet(e.ctx, e.list, e.options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve repeat variable %v: %w", name, err)
+		}
+		e.resolved = &set
+	}
+	for _, o := range e.resolved.Variables[name].Options {
+		if o != allValue {
+			options = append(options, o)
+		}
+	}
+
+	return options, nil
+}
+
+// scopedInterpolator interpolates the repeat variables of a copy.
+func scopedInterpolator(scoped map[string]string) *Interpolator {
+	vars := make(map[string]VariableValue, len(scoped))
+	for name, value := range scoped {
+		vars[name] = VariableValue{Values: []string{value}}
+	}
+	return NewInterpolator(vars)
+}
+
+// clonePanel copies a panel deeply enough that querying the copy, which sets keys on
+// its targets, does not change the original.
+func clonePanel(p Panel) Panel {
+	clone := p
+	clone.Targets = make([]any, len(p.Targets))
+	for i, t := range p.Targets {
+		if m, ok := t.(map[string]any); ok {
+			copied := make(map[string]any, len(m))
+			for k, v := range m {
+				copied[k] = v
+			}
+			t = copied
+		}
+		clone.Targets[i] = t
+	}
+	if p.Panels != nil {
+		clone.Panels = make([]Panel, len(p.Panels))
+		for i := range p.Panels {
+			clone.Panels[i] = clonePanel(p.Panels[i])
+		}
+	}
+	return clone
+}
@@ -1,22 +1,35 @@
 package grafanadata
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
-// Calls the http Client Do method
+// Calls the http Client Do method, retrying transient failures when a RetryPolicy is configured
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.retry != nil && isRetryableRequest(req) {
+		return c.doWithRetry(req)
+	}
+
 	resp, err := c.client.Do(req)
 	return resp, err
 }
 
 // NewRequest creates a new HTTP request with the API token included in the headers.
 func (c *Client) NewRequest(method, endpoint string, body io.Reader) (*http.Request, error) {
+	return c.NewRequestWithContext(context.Background(), method, endpoint, body)
+}
+
+// NewRequestWithContext creates a new HTTP request bound to ctx with the API token included in the headers.
+func (c *Client) NewRequestWithContext(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
 
 	// Create a new HTTP request
-	req, err := http.NewRequest(method, endpoint, body)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
 	if err != nil {
 		return nil, err
 	}
@@ -30,3 +43,38 @@ func (c *Client) NewRequest(method, endpoint string, body io.Reader) (*http.Requ
 
 	return req, nil
 }
+
+// getJSON gets an API path of the Grafana instance, e.g. "/api/library-elements",
+// and decodes the JSON response into out.
+func (c *Client) getJSON(ctx context.Context, path string, params url.Values, out any) error {
+	endpoint := strings.TrimSuffix(c.baseURL.String(), "/") + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(req, resp.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return nil
+}
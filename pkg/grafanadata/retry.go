@@ -0,0 +1,176 @@
+package grafanadata
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy configures how Client.Do retries requests that fail with a
+// transient status code or a network error.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// MinBackoff is the delay before the first retry. Each later retry doubles it.
+	MinBackoff time.Duration
+	// MaxBackoff caps the computed backoff. It does not cap a server supplied Retry-After.
+	MaxBackoff time.Duration
+	// RetryableStatusCodes are the response codes that trigger a retry.
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy returns a policy that retries 3 times on 429, 502, 503 and 504
+// with a backoff between 500ms and 10s.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 3,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// WithRetryPolicy enables retries of idempotent requests and panel data queries.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(client *Client) {
+		client.retry = &policy
+	}
+}
+
+func (p *RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a jittered delay for the given retry attempt, starting at 1.
+// The delay is picked uniformly from the upper half of the exponential window.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// isRetryableRequest reports whether the request can safely be sent more than once.
+// Idempotent methods are always retryable; POST is only retried for /api/ds/query
+// which does not modify any state in Grafana.
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	case http.MethodPost:
+		if !strings.HasSuffix(req.URL.Path, "/api/ds/query") {
+			return false
+		}
+	default:
+		return false
+	}
+
+	// the body must be re-readable for every attempt
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+func (c *Client) doWithRetry(req *http.Request) (*http.Response, error) {
+	policy := c.retry
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := c.client.Do(r)
+
+		last := attempt >= policy.MaxRetries
+		if err != nil {
+			if last || ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return resp, err
+			}
+		} else if last || !policy.retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		wait := policy.backoff(attempt + 1)
+		if err == nil {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				wait = d
+			}
+			// drain the body so the underlying connection can be reused
+			if resp.Body != nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			c.log.Debug("retrying request", "method", req.Method, "url", req.URL.String(),
+				"status", resp.StatusCode, "attempt", attempt+1, "wait", wait)
+		} else {
+			c.log.Debug("retrying request", "method", req.Method, "url", req.URL.String(),
+				"error", err, "attempt", attempt+1, "wait", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package grafanadata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Alignment is how WideTable and LongTable line up series sampled at different timestamps.
+type Alignment string
+
+const (
+	// AlignOuter keeps every timestamp of any series, missing samples are null.
+	AlignOuter Alignment = "outer"
+	// AlignInner keeps only the timestamps every series has a sample at.
+	AlignInner Alignment = "inner"
+	// AlignNearest uses the timestamps of the first series, and the other series take
+	// their nearest sample within the tolerance, by default half the smallest step of
+	// the first series.
+	AlignNearest Alignment = "nearest"
+	// AlignStepFill keeps every timestamp of any series, and series take their last
+	// sample at or before it, within the tolerance when one is set.
+	AlignStepFill Alignment = "stepfill"
+)
+
+// TableOption configures WideTable and LongTable.
+type TableOption func(*tableOptions)
+
+type tableOptions struct {
+	alignment Alignment
+	tolerance time.Duration
+}
+
+// WithAlignment sets how series with different timestamps are aligned, AlignOuter by default.
+func WithAlignment(alignment Alignment) TableOption {
+	return func(o *tableOptions) {
+		o.alignment = alignment
+	}
+}
+
+// WithTolerance sets how far from a timestamp AlignNearest and AlignStepFill take samples.
+func WithTolerance(tolerance time.Duration) TableOption {
+	return func(o *tableOptions) {
+		o.tolerance = tolerance
+	}
+}
+
+// Table is a panel result as a single table, ready for spreadsheets and dataframes.
+// Every row has a value per column: a time.Time for time columns, a string for string
+// columns and a float64 for numbers, or nil for nulls.
+type Table struct {
+	Columns []TableColumn
+	Rows    [][]any
+}
+
+// TableColumn describes a column of a Table.
+type TableColumn struct {
+	Name   string
+	Kind   FieldKind         // KindTime, KindString or KindFloat64
+	RefID  string            // the query of a series column
+	Labels map[string]string // the labels of a series column
+}
+
+// sampleSeries is a series of the results with its samples in time order.
+type sampleSeries struct {
+	ref    string
+	name   string
+	labels map[string]string
+	times  []time.Time
+	values []any // float64 or nil
+}
+
+// WideTable returns the series of the results as a table with a Time column and a
+// column per series, named by the display name of the series. Series are ordered by
+// refId, and series sharing a display name are numbered, e.g. "cpu 1" and "cpu 2".
+func (r Results) WideTable(opts ...TableOption) Table {
+	o := newTableOptions(opts...)
+	series := r.sampleSeries()
+	grid, values := alignSeries(series, o)
+
+	table := Table{Columns: []TableColumn{{Name: "Time", Kind: KindTime}}}
+	count := map[string]int{}
+	for _, s := range series {
+		count[s.name]++
+	}
+	index := map[string]int{}
+	for _, s := range series {
+		name := s.name
+		if count[name] > 1 {
+			index[name]++
+			name = fmt.Sprintf("%v %v", name, index[name])
+		}
+		table.Columns = append(table.Columns, TableColumn{Name: name, Kind: KindFloat64, RefID: s.ref, Labels: s.labels})
+	}
+
+	table.Rows = make([][]any, len(grid))
+	for k, t := range grid {
+		row := make([]any, 0, len(series)+1)
+		row = append(row, t)
+		for j := range series {
+			row = append(row, values[j][k])
+		}
+		table.Rows[k] = row
+	}
+
+	return table
+}
+
+// LongTable returns the series of the results as a table with a row per sample: the
+// Time, the display name of the series as Series, a column per label of any series and
+// the Value. Rows are ordered by time and then by series. Missing and null samples
+// have no row.
+func (r Results) LongTable(opts ...TableOption) Table {
+	o := newTableOptions(opts...)
+	series := r.sampleSeries()
+	grid, values := alignSeries(series, o)
+
+	seen := map[string]bool{}
+	var labelKeys []string
+	for _, s := range series {
+		for key := range s.labels {
+			if !seen[key] {
+				seen[key] = true
+				labelKeys = append(labelKeys, key)
+			}
+		}
+	}
+	sort.Strings(labelKeys)
+
+	table := Table{Columns: []TableColumn{{Name: "Time", Kind: KindTime}, {Name: "Series", Kind: KindString}}}
+	for _, key := range labelKeys {
+		table.Columns = append(table.Columns, TableColumn{Name: key, Kind: KindString})
+	}
+	table.Columns = append(table.Columns, TableColumn{Name: "Value", Kind: KindFloat64})
+
+	for k, t := range grid {
+		for j, s := range series {
+			if values[j][k] == nil {
+				continue
+			}
+			row := make([]any, 0, len(table.Columns))
+			row = append(row, t, s.name)
+			for _, key := range labelKeys {
+				if value, ok := s.labels[key]; ok {
+					row = append(row, value)
+				} else {
+					row = append(row, nil)
+				}
+			}
+			table.Rows = append(table.Rows, append(row, values[j][k]))
+		}
+	}
+
+	return table
+}
+
+// WriteCSV writes the table as CSV with a header of the column names. Times are
+// written in RFC 3339 and nulls as empty cells.
+func (t Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	record := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		record[i] = col.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write csv header with error %w", err)
+	}
+
+	for _, row := range t.Rows {
+		for i := range record {
+			record[i] = ""
+			if i >= len(row) {
+				continue
+			}
+			switch v := row[i].(type) {
+			case nil:
+			case time.Time:
+				record[i] = v.Format(time.RFC3339Nano)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write csv row with error %w", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func newTableOptions(opts ...TableOption) tableOptions {
+	o := tableOptions{alignment: AlignOuter}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// sampleSeries returns the series of the results split like
+// ConvertResultToPrometheusFormat does. Frames without a time field, like the output
+// of reduce expressions, give a single sample at the end of the range.
+func (r Results) sampleSeries() []sampleSeries {
+	refs := make([]string, 0, len(r.Results))
+	for ref := range r.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	names := newDisplayNamer(r)
+	var series []sampleSeries
+	for _, ref := range refs {
+		for _, frame := range r.Results[ref].Frames {
+			for _, fs := range splitFrame(ref, frame, r.Targets[ref].Format == FormatTable, names) {
+				s := sampleSeries{ref: ref, name: fs.name, labels: fs.labels}
+				for _, row := range fs.rows {
+					t := r.Range.To
+					if fs.times.Len() > 0 {
+						var ok bool
+						if t, ok = sampleTime(fs.times, row); !ok {
+							continue
+						}
+					}
+					s.times = append(s.times, t)
+					s.values = append(s.values, sampleValue(fs.values, row))
+				}
+				series = append(series, s.sorted())
+			}
+		}
+	}
+
+	return series
+}
+
+// sampleTime returns row i of a time column. Frames without schema hold epoch milliseconds.
+func sampleTime(col Column, i int) (time.Time, bool) {
+	if col.Kind == KindTime {
+		if i >= col.Len() || col.IsNull(i) {
+			return time.Time{}, false
+		}
+		return col.Times[i], true
+	}
+	ms, ok := col.Float64(i)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(ms*1e6)), true
+}
+
+// sampleValue returns row i of a numeric column as float64, or nil for nulls.
+func sampleValue(col Column, i int) any {
+	if f, ok := col.Float64(i); ok {
+		return f
+	}
+	return nil
+}
+
+func (s sampleSeries) sorted() sampleSeries {
+	order := make([]int, len(s.times))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return s.times[order[a]].Before(s.times[order[b]]) })
+
+	sorted := s
+	sorted.times = make([]time.Time, len(order))
+	sorted.values = make([]any, len(order))
+	for i, o := range order {
+		sorted.times[i], sorted.values[i] = s.times[o], s.values[o]
+	}
+	return sorted
+}
+
+// alignSeries returns the timestamps of the table and the value of every series at
+// each of them.
+func alignSeries(series []sampleSeries, o tableOptions) ([]time.Time, [][]any) {
+	var grid []time.Time
+	switch o.alignment {
+	case AlignInner:
+		grid = sharedTimes(series)
+	case AlignNearest:
+		if len(series) > 0 {
+			grid = uniqueTimes(series[:1])
+		}
+	default:
+		grid = uniqueTimes(series)
+	}
+
+	tolerance := o.tolerance
+	if o.alignment == AlignNearest && tolerance <= 0 {
+		tolerance = smallestStep(grid) / 2
+	}
+
+	values := make([][]any, len(series))
+	for j, s := range series {
+		values[j] = make([]any, len(grid))
+		for k, t := range grid {
+			switch o.alignment {
+			case AlignNearest:
+				values[j][k] = s.nearest(t, tolerance)
+			case AlignStepFill:
+				values[j][k] = s.previous(t, tolerance)
+			default:
+				values[j][k] = s.at(t)
+			}
+		}
+	}
+
+	return grid, values
+}
+
+// uniqueTimes returns the sorted timestamps of any of the series.
+func uniqueTimes(series []sampleSeries) []time.Time {
+	seen := map[int64]bool{}
+	var times []time.Time
+	for _, s := range series {
+		for _, t := range s.times {
+			if !seen[t.UnixNano()] {
+				seen[t.UnixNano()] = true
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a].Before(times[b]) })
+	return times
+}
+
+// sharedTimes returns the sorted timestamps every series has a sample at.
+func sharedTimes(series []sampleSeries) []time.Time {
+	var times []time.Time
+	for _, t := range uniqueTimes(series) {
+		shared := true
+		for _, s := range series {
+			if _, ok := s.search(t); !ok {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// smallestStep returns the smallest gap between sorted timestamps, or 0 for no gaps,
+// which leaves AlignNearest without a tolerance.
+func smallestStep(times []time.Time) time.Duration {
+	var step time.Duration
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap > 0 && (step == 0 || gap < step) {
+			step = gap
+		}
+	}
+	return step
+}
+
+// search returns the index of the last sample at or before t, and whether it is at t.
+func (s sampleSeries) search(t time.Time) (int, bool) {
+	i := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t) }) - 1
+	return i, i >= 0 && s.times[i].Equal(t)
+}
+
+// at returns the sample at t, or nil.
+func (s sampleSeries) at(t time.Time) any {
+	if i, ok := s.search(t); ok {
+		return s.values[i]
+	}
+	return nil
+}
+
+// previous returns the last non-null sample at or before t, within the tolerance if set.
+func (s sampleSeries) previous(t time.Time, tolerance time.Duration) any {
+	i, _ := s.search(t)
+	for ; i >= 0; i-- {
+		if tolerance > 0 && t.Sub(s.times[i]) > tolerance {
+			return nil
+		}
+		if s.values[i] != nil {
+			return s.values[i]
+		}
+	}
+	return nil
+}
+
+// nearest returns the non-null sample closest to t, the earlier one on ties, within
+// the tolerance if set.
+func (s sampleSeries) nearest(t time.Time, tolerance time.Duration) any {
+	before, _ := s.search(t)
+	for before >= 0 && s.values[before] == nil {
+		before--
+	}
+	after := before + 1
+	for after < len(s.times) && (s.values[after] == nil || s.times[after].Before(t)) {
+		after++
+	}
+
+	best := -1
+	switch {
+	case before >= 0 && after < len(s.times):
+		best = before
+		if s.times[after].Sub(t) < t.Sub(s.times[before]) {
+			best = after
+		}
+	case before >= 0:
+		best = before
+	case after < len(s.times):
+		best = after
+	}
+	if best < 0 {
+		return nil
+	}
+	if d := s.times[best].Sub(t); tolerance > 0 && (d > tolerance || -d > tolerance) {
+		return nil
+	}
+	return s.values[best]
+}
@@ -0,0 +1,59 @@
+package grafanadata
+
+// Formats of a query target.
+const (
+	FormatTimeSeries = "time_series"
+	FormatTable      = "table"
+	FormatHeatmap    = "heatmap"
+)
+
+// TargetOptions are the settings of a panel query target that change how it is
+// queried and how its frames are read.
+type TargetOptions struct {
+	RefID          string
+	Hide           bool    // hidden in the panel editor, not queried by default
+	Instant        bool    // query a single point at the end of the range
+	Range          bool    // query the whole range, may be combined with Instant
+	Format         string  // FormatTimeSeries (default), FormatTable or FormatHeatmap
+	Interval       string  // minimum interval of the target, e.g. "1m"
+	IntervalFactor float64 // multiplies the interval of older Prometheus queries, e.g. 2 for half the resolution
+	Expr           string  // query expression of Prometheus and Loki targets, interpolated once queried
+
+	Expression string   // server-side expression type, e.g. "math" or "reduce", empty for datasource queries
+	DependsOn  []string // refIds an expression reads
+}
+
+// IsExpression reports whether the target is a server-side expression.
+func (o TargetOptions) IsExpression() bool {
+	return o.Expression != ""
+}
+
+// parseTargetOptions reads the options of a query target.
+func parseTargetOptions(t map[string]any) TargetOptions {
+	opts := TargetOptions{Format: FormatTimeSeries}
+	opts.RefID, _ = t["refId"].(string)
+	opts.Hide, _ = t["hide"].(bool)
+	opts.Instant, _ = t["instant"].(bool)
+	opts.Range, _ = t["range"].(bool)
+	opts.Interval, _ = t["interval"].(string)
+	opts.IntervalFactor, _ = t["intervalFactor"].(float64)
+	opts.Expr, _ = t["expr"].(string)
+	if format, ok := t["format"].(string); ok && format != "" {
+		opts.Format = format
+	}
+	if isExpressionTarget(t) {
+		opts.Expression, _ = t["type"].(string)
+		if opts.Expression == "" {
+			opts.Expression = "math" // grafana's default expression type
+		}
+		opts.DependsOn = expressionRefs(t)
+	}
+	return opts
+}
+
+// WithHiddenTargets also queries the targets hidden in the panel editor.
+func WithHiddenTargets() PanelOption {
+	return func(o *panelOptions) {
+		o.includeHidden = true
+	}
+}
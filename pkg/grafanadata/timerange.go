@@ -0,0 +1,350 @@
+package grafanadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TimeRange is an absolute time range.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// TimeOptions controls how relative time expressions are evaluated.
+type TimeOptions struct {
+	// Location is used for rounding and for absolute dates without a zone. Defaults to time.Local.
+	Location *time.Location
+	// FiscalYearStartMonth is the zero based month the fiscal year starts in, used by fy and fQ.
+	FiscalYearStartMonth int
+	// WeekStart is the first day of the week, used by w rounding.
+	WeekStart time.Weekday
+}
+
+// TimeOptions returns the time options configured on the dashboard: its timezone,
+// fiscal year start month and week start.
+func (d Dashboard) TimeOptions() TimeOptions {
+	return TimeOptions{
+		Location:             dashboardLocation(d.Timezone),
+		FiscalYearStartMonth: d.FiscalYearStartMonth,
+		WeekStart:            parseWeekStart(d.WeekStart),
+	}
+}
+
+// TimeRange returns the absolute range of the dashboard's saved time picker at now.
+func (d Dashboard) TimeRange(now time.Time) (TimeRange, error) {
+	return ParseTimeRange(d.Time.From, d.Time.To, now, d.TimeOptions())
+}
+
+// dashboardLocation maps the dashboard timezone setting to a location. "browser"
+// and the empty default use the local timezone.
+func dashboardLocation(tz string) *time.Location {
+	switch strings.ToLower(tz) {
+	case "", "browser":
+		return time.Local
+	case "utc":
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func parseWeekStart(s string) time.Weekday {
+	switch strings.ToLower(s) {
+	case "monday":
+		return time.Monday
+	case "saturday":
+		return time.Saturday
+	}
+	return time.Sunday
+}
+
+// ParseTimeRange evaluates the from and to expressions of a time picker. Rounding in
+// from rounds down to the start of the unit while rounding in to rounds up to its end.
+func ParseTimeRange(from, to string, now time.Time, opts TimeOptions) (TimeRange, error) {
+	var tr TimeRange
+	var err error
+
+	if tr.From, err = ParseTime(from, now, false, opts); err != nil {
+		return tr, err
+	}
+	if tr.To, err = ParseTime(to, now, true, opts); err != nil {
+		return tr, err
+	}
+
+	return tr, nil
+}
+
+// absoluteLayouts are the absolute date formats accepted besides epoch milliseconds.
+var absoluteLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime evaluates a Grafana time expression such as "now-6h", "now/d",
+// "now-1w/w", "now/fy", an epoch in milliseconds or an absolute date.
+// roundUp selects the end instead of the start of the unit when rounding.
+func ParseTime(text string, now time.Time, roundUp bool, opts TimeOptions) (time.Time, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, fmt.Errorf("empty time expression")
+	}
+
+	if !strings.HasPrefix(text, "now") {
+		if ms, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return time.UnixMilli(ms).In(loc), nil
+		}
+
+		// absolute dates may be followed by math, e.g. "2024-01-01 00:00:00||+1d"
+		date, math, _ := strings.Cut(text, "||")
+		for _, layout := range absoluteLayouts {
+			t, err := time.ParseInLocation(layout, date, loc)
+			if err == nil {
+				return applyDateMath(t, math, roundUp, opts)
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time expression %q", text)
+	}
+
+	return applyDateMath(now.In(loc), strings.TrimPrefix(text, "now"), roundUp, opts)
+}
+
+// applyDateMath applies a chain of +N<unit>, -N<unit> and /<unit> operations.
+func applyDateMath(t time.Time, math string, roundUp bool, opts TimeOptions) (time.Time, error) {
+	s := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, math)
+
+	for i := 0; i < len(s); {
+		op := s[i]
+		i++
+		if op != '/' && op != '+' && op != '-' {
+			return time.Time{}, fmt.Errorf("invalid time math %q", math)
+		}
+
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		num := 1
+		if i > start {
+			n, err := strconv.Atoi(s[start:i])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time math %q: %w", math, err)
+			}
+			num = n
+		}
+		if op == '/' && num != 1 {
+			return time.Time{}, fmt.Errorf("invalid time math %q: rounding only works on single units", math)
+		}
+
+		if i >= len(s) {
+			return time.Time{}, fmt.Errorf("invalid time math %q: missing unit", math)
+		}
+		unit := s[i]
+		i++
+		fiscal := false
+		if unit == 'f' {
+			if i >= len(s) {
+				return time.Time{}, fmt.Errorf("invalid time math %q: missing unit", math)
+			}
+			fiscal = true
+			unit = s[i]
+			i++
+		}
+		if strings.IndexByte("yQMwdhms", unit) < 0 || fiscal && unit != 'y' && unit != 'Q' {
+			return time.Time{}, fmt.Errorf("invalid time math %q: unknown unit %q", math, unit)
+		}
+
+		switch op {
+		case '/':
+			if fiscal {
+				t = roundFiscal(t, unit, roundUp, opts.FiscalYearStartMonth)
+			} else {
+				t = roundTime(t, unit, roundUp, opts.WeekStart)
+			}
+		case '+':
+			t = addUnits(t, unit, num)
+		case '-':
+			t = addUnits(t, unit, -num)
+		}
+	}
+
+	return t, nil
+}
+
+func addUnits(t time.Time, unit byte, n int) time.Time {
+	switch unit {
+	case 'y':
+		return addMonths(t, 12*n)
+	case 'Q':
+		return addMonths(t, 3*n)
+	case 'M':
+		return addMonths(t, n)
+	case 'w':
+		return t.AddDate(0, 0, 7*n)
+	case 'd':
+		return t.AddDate(0, 0, n)
+	case 'h':
+		return t.Add(time.Duration(n) * time.Hour)
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute)
+	case 's':
+		return t.Add(time.Duration(n) * time.Second)
+	}
+	return t
+}
+
+// addMonths adds months clamping the day to the end of the target month, like moment.js
+// does, instead of overflowing into the following month.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// startOf returns the start of the unit containing t.
+func startOf(t time.Time, unit byte, weekStart time.Weekday) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+
+	switch unit {
+	case 'y':
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	case 'Q':
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, loc)
+	case 'M':
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case 'w':
+		offset := (int(t.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+	case 'd':
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case 'h':
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+	case 'm':
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+	case 's':
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, loc)
+	}
+	return t
+}
+
+// roundTime rounds t down to the start of the unit, or up to its last millisecond.
+func roundTime(t time.Time, unit byte, roundUp bool, weekStart time.Weekday) time.Time {
+	start := startOf(t, unit, weekStart)
+	if !roundUp {
+		return start
+	}
+	return addUnits(start, unit, 1).Add(-time.Millisecond)
+}
+
+// roundFiscal rounds to the fiscal year or quarter, which start in the fiscal start month.
+func roundFiscal(t time.Time, unit byte, roundUp bool, fiscalYearStartMonth int) time.Time {
+	y, m, _ := t.Date()
+
+	// months elapsed since the start of the fiscal year
+	elapsed := (int(m) - 1 - fiscalYearStartMonth%12 + 12) % 12
+	if unit == 'Q' {
+		elapsed %= 3
+	}
+	start := time.Date(y, m-time.Month(elapsed), 1, 0, 0, 0, 0, t.Location())
+
+	if !roundUp {
+		return start
+	}
+	return addUnits(start, unit, 1).Add(-time.Millisecond)
+}
+
+// timeUnitNames are used to describe relative ranges like Grafana's time picker does.
+var timeUnitNames = map[byte]string{
+	's': "second", 'm': "minute", 'h': "hour", 'd': "day", 'w': "week", 'M': "month", 'y': "year",
+}
+
+var simpleRelativeRange = regexp.MustCompile(`^now-(\d+)([smhdwMy])$`)
+
+// describeTextRange converts a panel relative time like "1h" or "now/d" into from and
+// to expressions and a human readable description.
+func describeTextRange(expr string) (from, to, display string) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "now") {
+		expr = "now-" + expr
+	}
+
+	from, to = expr, "now"
+	if strings.Contains(expr, "/") {
+		// rounded ranges like "now/d" cover the whole unit
+		to = expr
+	}
+
+	display = from + " to " + to
+	if m := simpleRelativeRange.FindStringSubmatch(expr); m != nil {
+		name := timeUnitNames[m[2][0]]
+		if m[1] != "1" {
+			name += "s"
+		}
+		display = "Last " + m[1] + " " + name
+	}
+
+	return from, to, display
+}
+
+// applyPanelTimeOverrides applies a panel's relative time and time shift to the
+// effective range like Grafana's panel query runner. The relative time only replaces
+// ranges that are relative to now. The description of the override is returned unless
+// the panel hides it.
+func applyPanelTimeOverrides(panel Panel, tr TimeRange, relative bool, now time.Time, opts TimeOptions, ip *Interpolator) (TimeRange, string, error) {
+	var info string
+
+	if timeFrom := ip.Interpolate(panel.TimeFrom, ""); timeFrom != "" && relative {
+		from, to, display := describeTextRange(timeFrom)
+		overridden, err := ParseTimeRange(from, to, now, opts)
+		if err != nil {
+			return tr, "", err
+		}
+		tr = overridden
+		info = display
+	}
+
+	if timeShift := ip.Interpolate(panel.TimeShift, ""); timeShift != "" {
+		shift := "-" + strings.TrimPrefix(strings.TrimSpace(timeShift), "-")
+		from, err := applyDateMath(tr.From, shift, false, opts)
+		if err != nil {
+			return tr, info, err
+		}
+		to, err := applyDateMath(tr.To, shift, true, opts)
+		if err != nil {
+			return tr, info, err
+		}
+		tr = TimeRange{From: from, To: to}
+		info = strings.TrimSpace(info + " timeshift " + shift)
+	}
+
+	if panel.HideTimeOverride {
+		info = ""
+	}
+
+	return tr, info, nil
+}
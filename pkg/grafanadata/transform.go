@@ -0,0 +1,1311 @@
+package grafanadata
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transformation is a step of the transformations of a panel, which Grafana applies
+// in the browser to the frames the queries return.
+type Transformation struct {
+	ID       string         `json:"id"` // e.g. "organize" or "joinByField"
+	Options  map[string]any `json:"options"`
+	Disabled bool           `json:"disabled,omitempty"`
+	Filter   *FieldMatcher  `json:"filter,omitempty"` // limits the step to some frames, e.g. {"id": "byRefId", "options": "A"}
+}
+
+// transformer applies a transformation to frames. names names their fields like
+// Grafana does before the field config of the panel applies.
+type transformer func(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error)
+
+// transformers are the implemented transformations by id.
+var transformers = map[string]transformer{
+	"organize":           organizeFields,
+	"merge":              mergeFrames,
+	"joinByField":        joinByField,
+	"seriesToColumns":    joinByField,
+	"reduce":             reduceFrames,
+	"calculateField":     calculateField,
+	"filterByValue":      filterByValue,
+	"filterFieldsByName": filterFieldsByName,
+	"renameByRegex":      renameByRegex,
+	"groupBy":            groupBy,
+	"sortBy":             sortBy,
+	"labelsToFields":     labelsToFields,
+	"limit":              limitRows,
+}
+
+// WithTransformations applies the transformations of the panel to the query results,
+// so that the data matches what the panel shows. See Results.Transform.
+func WithTransformations() PanelOption {
+	return func(o *panelOptions) {
+		o.transform = true
+	}
+}
+
+// WithTransformErrorsFatal makes panel queries fail when a transformation applied with
+// WithTransformations fails. Transformations that are not implemented are skipped and
+// logged either way.
+func WithTransformErrorsFatal() PanelOption {
+	return func(o *panelOptions) {
+		o.transformErrorFatal = true
+	}
+}
+
+// Transform applies transformations to the frames of the results like the panel does
+// and returns results holding the transformed frames grouped by refId. Frames built
+// from several frames, like joins, take the refId of the first. Legends are applied
+// to the fields before the transformations, as displayNameFromDS, so the returned
+// results have none.
+//
+// Transformations that are not implemented are skipped and reported in the returned
+// error, wrapping ErrUnsupportedTransformation, along with the results of the others.
+// If a transformation fails, the results are returned unchanged with its error.
+func (r Results) Transform(transformations []Transformation) (Results, error) {
+	refs := make([]string, 0, len(r.Results))
+	for ref := range r.Results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	// like the datasources, name the series by their legend before transforming them
+	legends := newDisplayNamer(Results{Legends: r.Legends, Targets: r.Targets, Results: r.Results})
+	var frames []Frame
+	for _, ref := range refs {
+		for _, frame := range r.Results[ref].Frames {
+			if frame.Schema.RefId == "" {
+				frame.Schema.RefId = ref
+			}
+			frame = selectFields(frame, allIndices(frame))
+			for i, field := range frame.Schema.Fields {
+				if name, ok := legends.legendName(ref, field); ok {
+					frame.Schema.Fields[i] = withConfig(field, "displayNameFromDS", name)
+				}
+			}
+			frames = append(frames, frame)
+		}
+	}
+
+	var errs []error
+	for _, t := range transformations {
+		if t.Disabled {
+			continue
+		}
+		transform, ok := transformers[t.ID]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %v", ErrUnsupportedTransformation, t.ID))
+			continue
+		}
+
+		var selected, others []Frame
+		for _, frame := range frames {
+			if t.Filter == nil || t.Filter.ID != "byRefId" || frameRefMatches(frame, t.Filter.Options) {
+				selected = append(selected, frame)
+			} else {
+				others = append(others, frame)
+			}
+		}
+
+		names := newFrameNamer(Results{Targets: r.Targets}, frames)
+		transformed, err := transform(selected, t.Options, names)
+		if err != nil {
+			return r, fmt.Errorf("failed to apply transformation %v with error %w", t.ID, err)
+		}
+		frames = append(transformed, others...)
+	}
+
+	result := r
+	result.Legends = nil
+	result.Results = make(map[string]Result, len(r.Results))
+	for ref, res := range r.Results {
+		res.Frames = nil
+		result.Results[ref] = res
+	}
+	for _, frame := range frames {
+		res := result.Results[frame.Schema.RefId]
+		res.Frames = append(res.Frames, frame)
+		result.Results[frame.Schema.RefId] = res
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// frameRefMatches reports whether the refId of a frame matches the option of a
+// byRefId filter, which is a refId or a /regex/.
+func frameRefMatches(frame Frame, option any) bool {
+	pattern, _ := option.(string)
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") {
+		re, err := compilePattern(pattern)
+		return err == nil && re.MatchString(frame.Schema.RefId)
+	}
+	return pattern == "" || pattern == frame.Schema.RefId
+}
+
+// fieldName returns the display name of field i of a frame.
+func (n *displayNamer) fieldName(frame Frame, i int) string {
+	return n.name(frame.Schema.RefId, frame, i)
+}
+
+// fieldIndex returns the index of the field with the given display name or name, or -1.
+func (n *displayNamer) fieldIndex(frame Frame, name string) int {
+	for i := range frame.Data.Values {
+		if n.fieldName(frame, i) == name {
+			return i
+		}
+	}
+	for i := range frame.Data.Values {
+		if frameField(frame, i).Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// selectFields returns a frame holding the given fields of a frame.
+func selectFields(frame Frame, indices []int) Frame {
+	out := Frame{Schema: frame.Schema}
+	out.Schema.Fields = make([]Field, 0, len(indices))
+	for _, i := range indices {
+		out.Schema.Fields = append(out.Schema.Fields, frameField(frame, i))
+		out.Data.Values = append(out.Data.Values, frame.Data.Values[i])
+	}
+	return out
+}
+
+// selectRows returns a frame holding the given rows of a frame.
+func selectRows(frame Frame, rows []int) Frame {
+	out := Frame{Schema: frame.Schema}
+	for _, col := range frame.Data.Values {
+		out.Data.Values = append(out.Data.Values, col.take(rows))
+	}
+	return out
+}
+
+// withConfig returns a copy of the field with a field config value set.
+func withConfig(field Field, key string, value any) Field {
+	config := make(map[string]any, len(field.Config)+1)
+	for k, v := range field.Config {
+		config[k] = v
+	}
+	if value == nil {
+		delete(config, key)
+	} else {
+		config[key] = value
+	}
+	field.Config = config
+	return field
+}
+
+// newField returns the schema of a field created by a transformation.
+func newField(name string, kind FieldKind) Field {
+	field := Field{Name: name, Type: fieldType(kind)}
+	if kind == KindInt64 {
+		field.TypeInfo = map[string]any{"frame": "int64"}
+	}
+	return field
+}
+
+// valueKey returns a key that is equal for equal values.
+func valueKey(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "\x00null"
+	case time.Time:
+		return "t" + strconv.FormatInt(x.UnixNano(), 10)
+	case string:
+		return "s" + x
+	}
+	if f, ok := toFloat64(v); ok {
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%T%v", v, v)
+}
+
+// compareValues orders values: nulls first, then numbers and times, booleans and strings.
+func compareValues(a, b any) int {
+	rank := func(v any) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 2
+		case string:
+			return 3
+		}
+		return 1
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	fa, fb := sortNumber(a), sortNumber(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func sortNumber(v any) float64 {
+	if t, ok := v.(time.Time); ok {
+		return float64(t.UnixNano())
+	}
+	f, _ := toFloat64(v)
+	return f
+}
+
+// organizeOptions are the options of the organize transformation.
+type organizeOptions struct {
+	ExcludeByName map[string]bool   `json:"excludeByName"`
+	IncludeByName map[string]bool   `json:"includeByName"`
+	IndexByName   map[string]int    `json:"indexByName"`
+	RenameByName  map[string]string `json:"renameByName"`
+}
+
+// organizeFields excludes, orders and renames fields by their display name.
+func organizeFields(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts organizeOptions
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		var indices []int
+		for i := range frame.Data.Values {
+			name := names.fieldName(frame, i)
+			if opts.ExcludeByName[name] || len(opts.IncludeByName) > 0 && !opts.IncludeByName[name] {
+				continue
+			}
+			indices = append(indices, i)
+		}
+
+		// fields without an index keep their order after the indexed ones
+		position := func(i int) int {
+			if p, ok := opts.IndexByName[names.fieldName(frame, i)]; ok {
+				return p
+			}
+			return math.MaxInt
+		}
+		sort.SliceStable(indices, func(a, b int) bool { return position(indices[a]) < position(indices[b]) })
+
+		organized := selectFields(frame, indices)
+		for j, i := range indices {
+			if rename := opts.RenameByName[names.fieldName(frame, i)]; rename != "" {
+				organized.Schema.Fields[j] = withConfig(organized.Schema.Fields[j], "displayName", rename)
+			}
+		}
+		out = append(out, organized)
+	}
+
+	return out, nil
+}
+
+// mergeFrames merges the frames into a single table. Fields are matched by display
+// name, and rows that agree on the fields every frame has are combined into one row.
+func mergeFrames(frames []Frame, _ map[string]any, names *displayNamer) ([]Frame, error) {
+	if len(frames) < 2 {
+		return frames, nil
+	}
+
+	var order []string
+	positions := map[string]int{}
+	var fields []Field
+	var kinds []FieldKind
+	frameCount := map[string]int{}
+	for _, frame := range frames {
+		seen := map[string]bool{}
+		for i, col := range frame.Data.Values {
+			name := names.fieldName(frame, i)
+			if _, ok := positions[name]; !ok {
+				positions[name] = len(order)
+				order = append(order, name)
+				fields = append(fields, newField(name, col.Kind))
+				kinds = append(kinds, col.Kind)
+			}
+			if !seen[name] {
+				seen[name] = true
+				frameCount[name]++
+			}
+		}
+	}
+
+	var common []int
+	for _, name := range order {
+		if frameCount[name] == len(frames) {
+			common = append(common, positions[name])
+		}
+	}
+
+	var rows [][]any
+	rowsByKey := map[string][]int{}
+	for _, frame := range frames {
+		for row := 0; row < frame.Rows(); row++ {
+			values := make([]any, len(order))
+			for i, col := range frame.Data.Values {
+				values[positions[names.fieldName(frame, i)]] = col.Value(row)
+			}
+
+			key := ""
+			for _, c := range common {
+				key += valueKey(values[c]) + "\x00"
+			}
+			merged := false
+			if len(common) > 0 {
+				for _, existing := range rowsByKey[key] {
+					if mergeRow(rows[existing], values) {
+						merged = true
+						break
+					}
+				}
+			}
+			if !merged {
+				rowsByKey[key] = append(rowsByKey[key], len(rows))
+				rows = append(rows, values)
+			}
+		}
+	}
+
+	out := Frame{Schema: Schema{RefId: frames[0].Schema.RefId, Fields: fields}}
+	for j := range order {
+		col := Column{Kind: kinds[j]}
+		for _, row := range rows {
+			col.appendValue(row[j])
+		}
+		out.Data.Values = append(out.Data.Values, col)
+	}
+
+	return []Frame{out}, nil
+}
+
+// mergeRow fills the nulls of row with values if no value conflicts.
+func mergeRow(row, values []any) bool {
+	for j, v := range values {
+		if v != nil && row[j] != nil && valueKey(v) != valueKey(row[j]) {
+			return false
+		}
+	}
+	for j, v := range values {
+		if v != nil {
+			row[j] = v
+		}
+	}
+	return true
+}
+
+// joinOptions are the options of the joinByField transformation.
+type joinOptions struct {
+	ByField string `json:"byField"` // defaults to the first time field
+	Mode    string `json:"mode"`    // "outer" (default) or "inner"
+}
+
+// joinByField joins the frames on a field into one frame. The other fields keep the
+// display name they had before the join. Frames without the field are left as they are.
+func joinByField(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts joinOptions
+	decodeProperty(options, &opts)
+	if len(frames) < 2 {
+		return frames, nil
+	}
+
+	type joined struct {
+		frame Frame
+		key   int
+		rows  map[string]int
+	}
+	var inputs []joined
+	var rest []Frame
+	var keys []string
+	keyValues := map[string]any{}
+	keyCount := map[string]int{}
+	for _, frame := range frames {
+		key := -1
+		if opts.ByField != "" {
+			key = names.fieldIndex(frame, opts.ByField)
+		} else {
+			for i, col := range frame.Data.Values {
+				if col.Kind == KindTime {
+					key = i
+					break
+				}
+			}
+		}
+		if key < 0 {
+			rest = append(rest, frame)
+			continue
+		}
+
+		in := joined{frame: frame, key: key, rows: map[string]int{}}
+		col := frame.Data.Values[key]
+		for row := 0; row < col.Len(); row++ {
+			v := col.Value(row)
+			k := valueKey(v)
+			if _, ok := in.rows[k]; !ok {
+				keyCount[k]++
+			}
+			if _, ok := keyValues[k]; !ok {
+				keyValues[k] = v
+				keys = append(keys, k)
+			}
+			in.rows[k] = row
+		}
+		inputs = append(inputs, in)
+	}
+	if len(inputs) == 0 {
+		return frames, nil
+	}
+
+	if opts.Mode == "inner" {
+		var inner []string
+		for _, k := range keys {
+			if keyCount[k] == len(inputs) {
+				inner = append(inner, k)
+			}
+		}
+		keys = inner
+	}
+	if kind := inputs[0].frame.Data.Values[inputs[0].key].Kind; kind != KindString {
+		sort.SliceStable(keys, func(a, b int) bool { return compareValues(keyValues[keys[a]], keyValues[keys[b]]) < 0 })
+	}
+
+	first := inputs[0]
+	keyCol := Column{Kind: first.frame.Data.Values[first.key].Kind}
+	for _, k := range keys {
+		keyCol.appendValue(keyValues[k])
+	}
+	out := Frame{Schema: Schema{RefId: first.frame.Schema.RefId}}
+	out.Schema.Fields = append(out.Schema.Fields, frameField(first.frame, first.key))
+	out.Data.Values = append(out.Data.Values, keyCol)
+
+	for _, in := range inputs {
+		rows := make([]int, len(keys))
+		for j, k := range keys {
+			row, ok := in.rows[k]
+			if !ok {
+				row = -1
+			}
+			rows[j] = row
+		}
+		for i, col := range in.frame.Data.Values {
+			if i == in.key {
+				continue
+			}
+			field := frameField(in.frame, i)
+			if _, ok := field.Config["displayName"]; !ok {
+				field = withConfig(field, "displayNameFromDS", names.fieldName(in.frame, i))
+			}
+			out.Schema.Fields = append(out.Schema.Fields, field)
+			out.Data.Values = append(out.Data.Values, col.take(rows))
+		}
+	}
+
+	return append([]Frame{out}, rest...), nil
+}
+
+// reducerNames are the names Grafana shows for reducers.
+var reducerNames = map[string]string{
+	"lastNotNull":   "Last *",
+	"last":          "Last",
+	"firstNotNull":  "First *",
+	"first":         "First",
+	"min":           "Min",
+	"max":           "Max",
+	"mean":          "Mean",
+	"median":        "Median",
+	"variance":      "Variance",
+	"stdDev":        "StdDev",
+	"sum":           "Total",
+	"count":         "Count",
+	"range":         "Range",
+	"delta":         "Delta",
+	"step":          "Step",
+	"diff":          "Difference",
+	"diffperc":      "Difference percent",
+	"logmin":        "Min (above zero)",
+	"allIsZero":     "All Zeros",
+	"allIsNull":     "All nulls",
+	"changeCount":   "Change count",
+	"distinctCount": "Distinct count",
+	"allValues":     "All values",
+	"uniqueValues":  "All unique values",
+}
+
+// reducerName returns the name Grafana shows for a reducer, e.g. "Total" for sum.
+func reducerName(id string) string {
+	if name, ok := reducerNames[id]; ok {
+		return name
+	}
+	if percentileRegex.MatchString(id) {
+		return id[1:] + "th %"
+	}
+	return id
+}
+
+// reduceTransformOptions are the options of the reduce transformation.
+type reduceTransformOptions struct {
+	Reducers         []string `json:"reducers"`
+	Mode             string   `json:"mode"` // "seriesToRows" (default) or "reduceFields"
+	IncludeTimeField bool     `json:"includeTimeField"`
+	LabelsToFields   bool     `json:"labelsToFields"`
+}
+
+// reduceFrames reduces every numeric field, into a table with a row per field and a
+// column per reducer, or, in reduceFields mode, into a single row of each frame.
+func reduceFrames(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts reduceTransformOptions
+	decodeProperty(options, &opts)
+	if len(opts.Reducers) == 0 {
+		return frames, nil
+	}
+
+	reduces := func(col Column) bool {
+		return isNumeric(col) || opts.IncludeTimeField && col.Kind == KindTime
+	}
+
+	if opts.Mode == "reduceFields" {
+		out := make([]Frame, 0, len(frames))
+		for _, frame := range frames {
+			reduced := Frame{Schema: frame.Schema}
+			reduced.Schema.Fields = nil
+			for i, col := range frame.Data.Values {
+				if !reduces(col) {
+					continue
+				}
+				values, err := Reduce(col, opts.Reducers...)
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range opts.Reducers {
+					field := frameField(frame, i)
+					if len(opts.Reducers) > 1 {
+						field = withConfig(field, "displayNameFromDS", names.fieldName(frame, i)+" "+reducerName(id))
+					}
+					reducedCol := columnOf([]any{values[id]})
+					field.Type = fieldType(reducedCol.Kind)
+					reduced.Schema.Fields = append(reduced.Schema.Fields, field)
+					reduced.Data.Values = append(reduced.Data.Values, reducedCol)
+				}
+			}
+			out = append(out, reduced)
+		}
+		return out, nil
+	}
+
+	var fieldNames []any
+	var labelKeys []string
+	labels := map[string][]any{}
+	calcs := make([][]any, len(opts.Reducers))
+	rows := 0
+	for _, frame := range frames {
+		for i, col := range frame.Data.Values {
+			if !reduces(col) {
+				continue
+			}
+			field := frameField(frame, i)
+			values, err := Reduce(col, opts.Reducers...)
+			if err != nil {
+				return nil, err
+			}
+
+			if opts.LabelsToFields {
+				fieldNames = append(fieldNames, field.Name)
+				for _, key := range sortedLabelKeys(field.Labels) {
+					if _, ok := labels[key]; !ok {
+						labels[key] = make([]any, rows)
+						labelKeys = append(labelKeys, key)
+					}
+				}
+				for _, key := range labelKeys {
+					var v any
+					if value, ok := field.Labels[key]; ok {
+						v = value
+					}
+					labels[key] = append(labels[key], v)
+				}
+			} else {
+				fieldNames = append(fieldNames, names.fieldName(frame, i))
+			}
+			for j, id := range opts.Reducers {
+				calcs[j] = append(calcs[j], values[id])
+			}
+			rows++
+		}
+	}
+
+	out := Frame{}
+	if len(frames) > 0 {
+		out.Schema.RefId = frames[0].Schema.RefId
+	}
+	out.Schema.Fields = append(out.Schema.Fields, newField("Field", KindString))
+	out.Data.Values = append(out.Data.Values, columnOf(fieldNames))
+	for _, key := range labelKeys {
+		out.Schema.Fields = append(out.Schema.Fields, newField(key, KindString))
+		col := Column{Kind: KindString}
+		for _, v := range labels[key] {
+			col.appendValue(v)
+		}
+		out.Data.Values = append(out.Data.Values, col)
+	}
+	for j, id := range opts.Reducers {
+		col := columnOf(calcs[j])
+		out.Schema.Fields = append(out.Schema.Fields, newField(reducerName(id), col.Kind))
+		out.Data.Values = append(out.Data.Values, col)
+	}
+
+	return []Frame{out}, nil
+}
+
+// calculateOptions are the options of the calculateField transformation.
+type calculateOptions struct {
+	Mode          string `json:"mode"` // "reduceRow" (default), "binary", "unary" or "index"
+	Alias         string `json:"alias"`
+	ReplaceFields bool   `json:"replaceFields"`
+	Reduce        struct {
+		Reducer string   `json:"reducer"`
+		Include []string `json:"include"`
+	} `json:"reduce"`
+	Binary struct {
+		Left     any    `json:"left"`
+		Operator string `json:"operator"`
+		Right    any    `json:"right"`
+	} `json:"binary"`
+	Unary struct {
+		Operator  string `json:"operator"`
+		FieldName string `json:"fieldName"`
+	} `json:"unary"`
+}
+
+// calculateField adds a field calculated from the other fields of each row.
+func calculateField(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts calculateOptions
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		var name string
+		var values func(row int) any
+
+		switch opts.Mode {
+		case "binary":
+			left, leftName := binaryOperand(frame, opts.Binary.Left, names)
+			right, rightName := binaryOperand(frame, opts.Binary.Right, names)
+			name = strings.TrimSpace(leftName + " " + opts.Binary.Operator + " " + rightName)
+			op := opts.Binary.Operator
+			values = func(row int) any {
+				l, lok := left(row)
+				r, rok := right(row)
+				if !lok || !rok {
+					return nil
+				}
+				return binaryOperation(op, l, r)
+			}
+		case "unary":
+			i := names.fieldIndex(frame, opts.Unary.FieldName)
+			name = opts.Unary.Operator + "(" + opts.Unary.FieldName + ")"
+			op := opts.Unary.Operator
+			values = func(row int) any {
+				if i < 0 {
+					return nil
+				}
+				v, ok := frame.Data.Values[i].Float64(row)
+				if !ok {
+					return nil
+				}
+				return unaryOperation(op, v)
+			}
+		case "index":
+			name = "Row"
+			values = func(row int) any { return float64(row) }
+		default:
+			reducer := opts.Reduce.Reducer
+			if reducer == "" {
+				reducer = "sum"
+			}
+			name = reducerName(reducer)
+			include := map[string]bool{}
+			for _, n := range opts.Reduce.Include {
+				include[n] = true
+			}
+			var inputs []int
+			for i, col := range frame.Data.Values {
+				if isNumeric(col) && (len(include) == 0 || include[names.fieldName(frame, i)]) {
+					inputs = append(inputs, i)
+				}
+			}
+			if _, err := Reduce(Column{}, reducer); err != nil {
+				return nil, err
+			}
+			values = func(row int) any {
+				col := Column{Kind: KindFloat64}
+				for _, i := range inputs {
+					col.appendValue(frame.Data.Values[i].Value(row))
+				}
+				reduced, _ := Reduce(col, reducer)
+				return reduced[reducer]
+			}
+		}
+		if opts.Alias != "" {
+			name = opts.Alias
+		}
+
+		col := Column{Kind: KindFloat64}
+		for row := 0; row < frame.Rows(); row++ {
+			col.appendValue(values(row))
+		}
+
+		calculated := frame
+		if opts.ReplaceFields {
+			var times []int
+			for i, c := range frame.Data.Values {
+				if c.Kind == KindTime {
+					times = append(times, i)
+				}
+			}
+			calculated = selectFields(frame, times)
+		} else {
+			calculated = selectFields(frame, allIndices(frame))
+		}
+		calculated.Schema.Fields = append(calculated.Schema.Fields, newField(name, KindFloat64))
+		calculated.Data.Values = append(calculated.Data.Values, col)
+		out = append(out, calculated)
+	}
+
+	return out, nil
+}
+
+// allIndices returns the indices of every field of a frame.
+func allIndices(frame Frame) []int {
+	indices := make([]int, len(frame.Data.Values))
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
+
+// binaryOperand returns the values of an operand of a binary calculation, which is a
+// field name or a number, written as a string or as {"fixed": "2"} or {"matcher": ...}.
+func binaryOperand(frame Frame, operand any, names *displayNamer) (func(row int) (float64, bool), string) {
+	var s string
+	switch o := operand.(type) {
+	case string:
+		s = o
+	case float64:
+		s = strconv.FormatFloat(o, 'f', -1, 64)
+	case map[string]any:
+		if fixed, ok := o["fixed"].(string); ok {
+			s = fixed
+		} else if matcher, ok := o["matcher"].(map[string]any); ok {
+			s, _ = matcher["options"].(string)
+		}
+	}
+
+	if i := names.fieldIndex(frame, s); i >= 0 {
+		col := frame.Data.Values[i]
+		return col.Float64, s
+	}
+	if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return func(int) (float64, bool) { return f, true }, s
+	}
+	return func(int) (float64, bool) { return 0, false }, s
+}
+
+func binaryOperation(op string, l, r float64) any {
+	switch op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		return l / r
+	case "%":
+		return math.Mod(l, r)
+	case "^", "**":
+		return math.Pow(l, r)
+	}
+	return nil
+}
+
+func unaryOperation(op string, v float64) any {
+	switch op {
+	case "abs":
+		return math.Abs(v)
+	case "exp":
+		return math.Exp(v)
+	case "ln":
+		return math.Log(v)
+	case "floor":
+		return math.Floor(v)
+	case "ceil":
+		return math.Ceil(v)
+	}
+	return nil
+}
+
+// filterByValueOptions are the options of the filterByValue transformation.
+type filterByValueOptions struct {
+	Type    string `json:"type"`  // "include" (default) or "exclude"
+	Match   string `json:"match"` // "any" (default) or "all"
+	Filters []struct {
+		FieldName string `json:"fieldName"`
+		Config    struct {
+			ID      string         `json:"id"`
+			Options map[string]any `json:"options"`
+		} `json:"config"`
+	} `json:"filters"`
+}
+
+// filterByValue keeps or drops the rows matching the value filters.
+func filterByValue(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts filterByValueOptions
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		type filter struct {
+			field   int
+			matches func(any) bool
+		}
+		var filters []filter
+		for _, f := range opts.Filters {
+			i := names.fieldIndex(frame, f.FieldName)
+			if i < 0 {
+				continue
+			}
+			matches, err := valueMatcher(f.Config.ID, f.Config.Options)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter{field: i, matches: matches})
+		}
+		if len(filters) == 0 {
+			out = append(out, frame)
+			continue
+		}
+
+		var rows []int
+		for row := 0; row < frame.Rows(); row++ {
+			matched := opts.Match == "all"
+			for _, f := range filters {
+				m := f.matches(reduceValue(frame.Data.Values[f.field], row))
+				if opts.Match == "all" {
+					matched = matched && m
+				} else {
+					matched = matched || m
+				}
+			}
+			if matched == (opts.Type != "exclude") {
+				rows = append(rows, row)
+			}
+		}
+		out = append(out, selectRows(frame, rows))
+	}
+
+	return out, nil
+}
+
+// valueMatcher returns a matcher of the values filterByValue compares.
+func valueMatcher(id string, options map[string]any) (func(any) bool, error) {
+	number := func(key string) (float64, bool) {
+		switch v := options[key].(type) {
+		case float64:
+			return v, true
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return f, err == nil
+		}
+		return 0, false
+	}
+	compare := func(cmp func(v, o float64) bool) func(any) bool {
+		o, ok := number("value")
+		return func(v any) bool {
+			f, isNumber := toFloat64(v)
+			return ok && isNumber && cmp(f, o)
+		}
+	}
+	equal := func(v any) bool {
+		if v == nil {
+			return options["value"] == nil
+		}
+		if o, ok := number("value"); ok {
+			if f, isNumber := toFloat64(v); isNumber {
+				return f == o
+			}
+		}
+		return fmt.Sprint(v) == fmt.Sprint(options["value"])
+	}
+
+	switch id {
+	case "greater":
+		return compare(func(v, o float64) bool { return v > o }), nil
+	case "greaterOrEqual":
+		return compare(func(v, o float64) bool { return v >= o }), nil
+	case "lower":
+		return compare(func(v, o float64) bool { return v < o }), nil
+	case "lowerOrEqual":
+		return compare(func(v, o float64) bool { return v <= o }), nil
+	case "equal":
+		return equal, nil
+	case "notEqual":
+		return func(v any) bool { return !equal(v) }, nil
+	case "isNull":
+		return func(v any) bool { return v == nil }, nil
+	case "isNotNull":
+		return func(v any) bool { return v != nil }, nil
+	case "range":
+		from, fromOK := number("from")
+		to, toOK := number("to")
+		return func(v any) bool {
+			f, ok := toFloat64(v)
+			return ok && fromOK && toOK && f >= from && f <= to
+		}, nil
+	case "regex":
+		pattern, _ := options["value"].(string)
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse regex %v with error %w", pattern, err)
+		}
+		return func(v any) bool { return v != nil && re.MatchString(fmt.Sprint(v)) }, nil
+	}
+	return nil, fmt.Errorf("unknown value matcher %v", id)
+}
+
+// fieldNamesOptions select fields by name or pattern.
+type fieldNamesOptions struct {
+	Names   []string `json:"names"`
+	Pattern string   `json:"pattern"`
+}
+
+func (o fieldNamesOptions) matches(name string) bool {
+	for _, n := range o.Names {
+		if n == name {
+			return true
+		}
+	}
+	if o.Pattern != "" {
+		re, err := compilePattern(o.Pattern)
+		return err == nil && re.MatchString(name)
+	}
+	return false
+}
+
+// filterFieldsByName keeps the included fields that are not excluded.
+func filterFieldsByName(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts struct {
+		Include *fieldNamesOptions `json:"include"`
+		Exclude *fieldNamesOptions `json:"exclude"`
+	}
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		var indices []int
+		for i := range frame.Data.Values {
+			name := names.fieldName(frame, i)
+			if opts.Include != nil && !opts.Include.matches(name) || opts.Exclude != nil && opts.Exclude.matches(name) {
+				continue
+			}
+			indices = append(indices, i)
+		}
+		out = append(out, selectFields(frame, indices))
+	}
+
+	return out, nil
+}
+
+// jsReplacementRegex matches the $1 group references of javascript replacements.
+var jsReplacementRegex = regexp.MustCompile(`\$(\d+)`)
+
+// renameByRegex renames the fields whose display name matches a regex, replacing the
+// first match like javascript's String.replace.
+func renameByRegex(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts struct {
+		Regex         string `json:"regex"`
+		RenamePattern string `json:"renamePattern"`
+	}
+	decodeProperty(options, &opts)
+	if opts.Regex == "" {
+		return frames, nil
+	}
+	re, err := compilePattern(opts.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse regex %v with error %w", opts.Regex, err)
+	}
+	template := jsReplacementRegex.ReplaceAllString(opts.RenamePattern, "$${$1}")
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		renamed := selectFields(frame, allIndices(frame))
+		for i := range renamed.Schema.Fields {
+			name := names.fieldName(frame, i)
+			match := re.FindStringSubmatchIndex(name)
+			if match == nil {
+				continue
+			}
+			replaced := name[:match[0]] + string(re.ExpandString(nil, template, name, match)) + name[match[1]:]
+			renamed.Schema.Fields[i] = withConfig(renamed.Schema.Fields[i], "displayName", replaced)
+		}
+		out = append(out, renamed)
+	}
+
+	return out, nil
+}
+
+// groupByOptions are the options of the groupBy transformation.
+type groupByOptions struct {
+	Fields map[string]struct {
+		Aggregations []string `json:"aggregations"`
+		Operation    string   `json:"operation"` // "groupby" or "aggregate"
+	} `json:"fields"`
+}
+
+// groupBy groups the rows by the values of the groupby fields and aggregates the
+// aggregate fields of each group, naming them like "Value (mean)".
+func groupBy(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts groupByOptions
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		var keys, aggregates []int
+		for i := range frame.Data.Values {
+			switch opts.Fields[names.fieldName(frame, i)].Operation {
+			case "groupby":
+				keys = append(keys, i)
+			case "aggregate":
+				aggregates = append(aggregates, i)
+			}
+		}
+		if len(keys) == 0 {
+			out = append(out, frame)
+			continue
+		}
+
+		var groups [][]int
+		groupByKey := map[string]int{}
+		for row := 0; row < frame.Rows(); row++ {
+			key := ""
+			for _, i := range keys {
+				key += valueKey(frame.Data.Values[i].Value(row)) + "\x00"
+			}
+			g, ok := groupByKey[key]
+			if !ok {
+				g = len(groups)
+				groupByKey[key] = g
+				groups = append(groups, nil)
+			}
+			groups[g] = append(groups[g], row)
+		}
+
+		firsts := make([]int, len(groups))
+		for g, rows := range groups {
+			firsts[g] = rows[0]
+		}
+		grouped := selectRows(selectFields(frame, keys), firsts)
+
+		for _, i := range aggregates {
+			name := names.fieldName(frame, i)
+			for _, calc := range opts.Fields[name].Aggregations {
+				values := make([]any, len(groups))
+				for g, rows := range groups {
+					reduced, err := Reduce(frame.Data.Values[i].take(rows), calc)
+					if err != nil {
+						return nil, err
+					}
+					values[g] = reduced[calc]
+				}
+				col := columnOf(values)
+				grouped.Schema.Fields = append(grouped.Schema.Fields, newField(name+" ("+calc+")", col.Kind))
+				grouped.Data.Values = append(grouped.Data.Values, col)
+			}
+		}
+		out = append(out, grouped)
+	}
+
+	return out, nil
+}
+
+// sortBy sorts the rows by fields, the first given field first.
+func sortBy(frames []Frame, options map[string]any, names *displayNamer) ([]Frame, error) {
+	var opts struct {
+		Sort []struct {
+			Field string `json:"field"`
+			Desc  bool   `json:"desc"`
+		} `json:"sort"`
+	}
+	decodeProperty(options, &opts)
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		type key struct {
+			col  Column
+			desc bool
+		}
+		var keys []key
+		for _, s := range opts.Sort {
+			if i := names.fieldIndex(frame, s.Field); i >= 0 {
+				keys = append(keys, key{frame.Data.Values[i], s.Desc})
+			}
+		}
+		if len(keys) == 0 {
+			out = append(out, frame)
+			continue
+		}
+
+		rows := make([]int, frame.Rows())
+		for i := range rows {
+			rows[i] = i
+		}
+		sort.SliceStable(rows, func(a, b int) bool {
+			for _, k := range keys {
+				c := compareValues(k.col.Value(rows[a]), k.col.Value(rows[b]))
+				if k.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+		out = append(out, selectRows(frame, rows))
+	}
+
+	return out, nil
+}
+
+// labelsToFields turns the labels of fields into string fields, or in rows mode into
+// a table of label and value.
+func labelsToFields(frames []Frame, options map[string]any, _ *displayNamer) ([]Frame, error) {
+	var opts struct {
+		Mode       string   `json:"mode"` // "columns" (default) or "rows"
+		ValueLabel string   `json:"valueLabel"`
+		KeepLabels []string `json:"keepLabels"`
+	}
+	decodeProperty(options, &opts)
+	keep := map[string]bool{}
+	for _, l := range opts.KeepLabels {
+		keep[l] = true
+	}
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		if opts.Mode == "rows" {
+			var labelNames, labelValues []any
+			for _, field := range frame.Schema.Fields {
+				for _, key := range sortedLabelKeys(field.Labels) {
+					labelNames = append(labelNames, key)
+					labelValues = append(labelValues, field.Labels[key])
+				}
+			}
+			rows := Frame{Schema: Schema{Name: frame.Schema.Name, RefId: frame.Schema.RefId}}
+			rows.Schema.Fields = []Field{newField("label", KindString), newField("value", KindString)}
+			rows.Data.Values = []Column{
+				{Kind: KindString, Strings: toStrings(labelNames)},
+				{Kind: KindString, Strings: toStrings(labelValues)},
+			}
+			out = append(out, rows)
+			continue
+		}
+
+		converted := Frame{Schema: frame.Schema}
+		converted.Schema.Fields = nil
+		var valueFields []int
+		for i := range frame.Data.Values {
+			if len(frameField(frame, i).Labels) == 0 {
+				converted.Schema.Fields = append(converted.Schema.Fields, frameField(frame, i))
+				converted.Data.Values = append(converted.Data.Values, frame.Data.Values[i])
+			} else {
+				valueFields = append(valueFields, i)
+			}
+		}
+
+		added := map[string]bool{}
+		for _, i := range valueFields {
+			labels := frameField(frame, i).Labels
+			for _, key := range sortedLabelKeys(labels) {
+				if added[key] || key == opts.ValueLabel || len(keep) > 0 && !keep[key] {
+					continue
+				}
+				added[key] = true
+				col := Column{Kind: KindString, Strings: make([]string, frame.Rows())}
+				for row := range col.Strings {
+					col.Strings[row] = labels[key]
+				}
+				converted.Schema.Fields = append(converted.Schema.Fields, newField(key, KindString))
+				converted.Data.Values = append(converted.Data.Values, col)
+			}
+		}
+
+		for _, i := range valueFields {
+			field := frameField(frame, i)
+			if value, ok := field.Labels[opts.ValueLabel]; ok && opts.ValueLabel != "" {
+				field.Name = value
+			}
+			field.Labels = nil
+			field = withConfig(field, "displayNameFromDS", nil)
+			converted.Schema.Fields = append(converted.Schema.Fields, field)
+			converted.Data.Values = append(converted.Data.Values, frame.Data.Values[i])
+		}
+		out = append(out, converted)
+	}
+
+	return out, nil
+}
+
+func toStrings(values []any) []string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return s
+}
+
+// limitRows keeps the first rows of every frame, 10 unless limitField is set.
+func limitRows(frames []Frame, options map[string]any, _ *displayNamer) ([]Frame, error) {
+	limit := 10
+	if v, ok := options["limitField"].(string); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			limit = n
+		}
+	} else if f, ok := toFloat64(options["limitField"]); ok {
+		limit = int(f)
+	}
+
+	out := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		if frame.Rows() <= limit || limit < 0 {
+			out = append(out, frame)
+			continue
+		}
+		rows := make([]int, limit)
+		for i := range rows {
+			rows[i] = i
+		}
+		out = append(out, selectRows(frame, rows))
+	}
+
+	return out, nil
+}
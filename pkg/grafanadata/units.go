@@ -0,0 +1,403 @@
+package grafanadata
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unitFormatter formats a value into its text and unit suffix. decimals is nil to
+// pick the decimals by the size of the value.
+type unitFormatter func(value float64, decimals *int) (prefix, text, suffix string)
+
+// siPrefixes are the prefixes of decimal SI units, siBaseIndex is the unscaled one.
+var siPrefixes = []string{"f", "p", "n", "µ", "m", "", "k", "M", "G", "T", "P", "E", "Z", "Y"}
+
+const siBaseIndex = 5
+
+// binaryPrefixes are the prefixes of IEC units.
+var binaryPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"}
+
+// units is the catalogue of Grafana units by id.
+var units = map[string]unitFormatter{
+	// misc
+	"":            fixedUnit(""),
+	"none":        fixedUnit(""),
+	"short":       scaledUnits(1000, []string{"", " K", " Mil", " Bil", " Tri", " Quadr", " Quint", " Sext", " Sept"}, 0),
+	"percent":     percent(1),
+	"percentunit": percent(100),
+	"humidity":    fixedUnit("%H"),
+	"dB":          fixedUnit("dB"),
+	"ppm":         fixedUnit("ppm"),
+	"sishort":     decimalSIPrefix("", 0),
+
+	// data
+	"bytes":     binaryPrefix("B", 0),
+	"decbytes":  decimalSIPrefix("B", 0),
+	"bits":      binaryPrefix("b", 0),
+	"decbits":   decimalSIPrefix("b", 0),
+	"kbytes":    binaryPrefix("B", 1),
+	"mbytes":    binaryPrefix("B", 2),
+	"gbytes":    binaryPrefix("B", 3),
+	"tbytes":    binaryPrefix("B", 4),
+	"pbytes":    binaryPrefix("B", 5),
+	"deckbytes": decimalSIPrefix("B", 1),
+	"decmbytes": decimalSIPrefix("B", 2),
+	"decgbytes": decimalSIPrefix("B", 3),
+	"dectbytes": decimalSIPrefix("B", 4),
+	"decpbytes": decimalSIPrefix("B", 5),
+
+	// data rate
+	"pps":    decimalSIPrefix("p/s", 0),
+	"binBps": binaryPrefix("B/s", 0),
+	"Bps":    decimalSIPrefix("B/s", 0),
+	"binbps": binaryPrefix("b/s", 0),
+	"bps":    decimalSIPrefix("b/s", 0),
+	"KiBs":   binaryPrefix("B/s", 1),
+	"Kibits": binaryPrefix("b/s", 1),
+	"KBs":    decimalSIPrefix("B/s", 1),
+	"Kbits":  decimalSIPrefix("b/s", 1),
+	"MiBs":   binaryPrefix("B/s", 2),
+	"Mibits": binaryPrefix("b/s", 2),
+	"MBs":    decimalSIPrefix("B/s", 2),
+	"Mbits":  decimalSIPrefix("b/s", 2),
+	"GiBs":   binaryPrefix("B/s", 3),
+	"Gibits": binaryPrefix("b/s", 3),
+	"GBs":    decimalSIPrefix("B/s", 3),
+	"Gbits":  decimalSIPrefix("b/s", 3),
+	"TiBs":   binaryPrefix("B/s", 4),
+	"Tibits": binaryPrefix("b/s", 4),
+	"TBs":    decimalSIPrefix("B/s", 4),
+	"Tbits":  decimalSIPrefix("b/s", 4),
+	"PiBs":   binaryPrefix("B/s", 5),
+	"Pibits": binaryPrefix("b/s", 5),
+	"PBs":    decimalSIPrefix("B/s", 5),
+	"Pbits":  decimalSIPrefix("b/s", 5),
+
+	// throughput
+	"cps":   countUnit("c/s"),
+	"ops":   countUnit("ops"),
+	"reqps": countUnit("req/s"),
+	"rps":   countUnit("rd/s"),
+	"wps":   countUnit("wr/s"),
+	"iops":  countUnit("io/s"),
+	"eps":   countUnit("evt/s"),
+	"mps":   countUnit("msg/s"),
+	"cpm":   countUnit("c/m"),
+	"opm":   countUnit("ops/min"),
+	"rpm":   countUnit("rd/min"),
+	"wpm":   countUnit("wr/min"),
+
+	// time
+	"hertz": decimalSIPrefix("Hz", 0),
+	"ns":    toNanoseconds,
+	"µs":    toMicroseconds,
+	"ms":    toMilliseconds,
+	"s":     toSeconds,
+	"m":     toMinutes,
+	"h":     toHours,
+	"d":     toDays,
+
+	// energy
+	"watt":    decimalSIPrefix("W", 0),
+	"kwatt":   decimalSIPrefix("W", 1),
+	"mwatt":   decimalSIPrefix("W", -1),
+	"voltamp": decimalSIPrefix("VA", 0),
+	"watth":   decimalSIPrefix("Wh", 0),
+	"kwatth":  decimalSIPrefix("Wh", 1),
+	"joule":   decimalSIPrefix("J", 0),
+	"amp":     decimalSIPrefix("A", 0),
+	"mamp":    decimalSIPrefix("A", -1),
+	"volt":    decimalSIPrefix("V", 0),
+	"mvolt":   decimalSIPrefix("V", -1),
+	"ohm":     decimalSIPrefix("Ω", 0),
+
+	// temperature
+	"celsius":    fixedUnit("°C"),
+	"fahrenheit": fixedUnit("°F"),
+	"kelvin":     fixedUnit("K"),
+
+	// currency
+	"currencyUSD": currency("$"),
+	"currencyGBP": currency("£"),
+	"currencyEUR": currency("€"),
+	"currencyJPY": currency("¥"),
+
+	// boolean
+	"bool":        boolUnit("True", "False"),
+	"bool_yes_no": boolUnit("Yes", "No"),
+	"bool_on_off": boolUnit("On", "Off"),
+}
+
+// FormatUnit formats a value in a Grafana unit, returning the prefix, the number and
+// the suffix, e.g. "", "1.50", " GiB". Besides the units of the catalogue, custom
+// units like "suffix:rpm", "prefix:€", "si:g", "count:req" and "currency:CHF" are
+// supported. Unknown units are appended as a suffix, like Grafana does.
+func FormatUnit(unit string, value float64, decimals *int) (prefix, text, suffix string) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return "", promValue(value).(string), ""
+	}
+	return unitFormatterFor(unit)(value, decimals)
+}
+
+func unitFormatterFor(unit string) unitFormatter {
+	if f, ok := units[unit]; ok {
+		return f
+	}
+
+	if kind, sub, ok := strings.Cut(unit, ":"); ok {
+		switch kind {
+		case "prefix":
+			return prefixUnit(sub)
+		case "suffix":
+			return fixedUnit(sub)
+		case "si":
+			offset := 0
+			for i, p := range siPrefixes {
+				if p != "" && strings.HasPrefix(sub, p) && len(sub) > len(p) {
+					offset, sub = i-siBaseIndex, strings.TrimPrefix(sub, p)
+					break
+				}
+			}
+			return decimalSIPrefix(sub, offset)
+		case "count":
+			return countUnit(sub)
+		case "currency":
+			return currency(sub)
+		}
+	}
+
+	return fixedUnit(unit)
+}
+
+// fixedUnit formats the value as is with the unit as suffix.
+func fixedUnit(unit string) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		if unit == "" {
+			return "", toFixed(value, decimals), ""
+		}
+		return "", toFixed(value, decimals), " " + unit
+	}
+}
+
+// prefixUnit formats the value as is with the unit as prefix.
+func prefixUnit(unit string) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		return unit, toFixed(value, decimals), ""
+	}
+}
+
+// percent formats percentages, scaling fractions by 100 for percentunit.
+func percent(scale float64) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		return "", toFixed(value*scale, decimals), "%"
+	}
+}
+
+// scaledUnits divides the value by powers of factor, using the matching suffix.
+// offset is the index of the suffix of unscaled values.
+func scaledUnits(factor float64, suffixes []string, offset int) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		index := 0
+		if value != 0 {
+			index = int(math.Floor(math.Log(math.Abs(value)) / math.Log(factor)))
+			// the logarithm may be off by a rounding error at exact powers
+			if math.Abs(value) >= math.Pow(factor, float64(index+1)) {
+				index++
+			} else if math.Abs(value) < math.Pow(factor, float64(index)) {
+				index--
+			}
+		}
+		step := min(max(offset+index, 0), len(suffixes)-1)
+		return "", toFixed(value/math.Pow(factor, float64(step-offset)), decimals), suffixes[step]
+	}
+}
+
+func decimalSIPrefix(unit string, offset int) unitFormatter {
+	suffixes := make([]string, len(siPrefixes))
+	for i, p := range siPrefixes {
+		suffixes[i] = " " + p + unit
+	}
+	return scaledUnits(1000, suffixes, siBaseIndex+offset)
+}
+
+func binaryPrefix(unit string, offset int) unitFormatter {
+	suffixes := make([]string, len(binaryPrefixes))
+	for i, p := range binaryPrefixes {
+		suffixes[i] = " " + p + unit
+	}
+	return scaledUnits(1024, suffixes, offset)
+}
+
+// countUnit scales counts by thousands, e.g. "1.20K ops".
+func countUnit(symbol string) unitFormatter {
+	scaled := scaledUnits(1000, []string{"", "K", "M", "B", "T"}, 0)
+	return func(value float64, decimals *int) (string, string, string) {
+		_, text, suffix := scaled(value, decimals)
+		return "", text, suffix + " " + symbol
+	}
+}
+
+// currency scales amounts by thousands and prefixes the symbol, e.g. "$1.20K".
+func currency(symbol string) unitFormatter {
+	scaled := scaledUnits(1000, []string{"", "K", "M", "B", "T"}, 0)
+	return func(value float64, decimals *int) (string, string, string) {
+		_, text, suffix := scaled(value, decimals)
+		return symbol, text, suffix
+	}
+}
+
+// durationStep is a unit of durations, used for values below limit, which are
+// divided by size. The last step of a unit is used for any larger value.
+type durationStep struct {
+	limit  float64
+	size   float64
+	suffix string
+}
+
+// durationSteps formats durations in the first step they are below the limit of.
+func durationSteps(steps ...durationStep) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		step := steps[len(steps)-1]
+		for _, s := range steps {
+			if math.Abs(value) < s.limit {
+				step = s
+				break
+			}
+		}
+		scaled := value / step.size
+		if step.size < 1 {
+			// like Grafana, multiply by the inverse, e.g. 1e6 for µs, which is exact
+			scaled = value * math.Round(1/step.size)
+		}
+		return "", toFixed(scaled, decimals), step.suffix
+	}
+}
+
+// The duration units of Grafana. Each has its own steps: nanoseconds stop at days,
+// microseconds at seconds, milliseconds skip weeks, and durations of less than one
+// minute, hour or day are shown in the next smaller unit.
+var (
+	toNanoseconds = durationSteps(
+		durationStep{1e3, 1, " ns"},
+		durationStep{1e6, 1e3, " µs"},
+		durationStep{1e9, 1e6, " ms"},
+		durationStep{6e10, 1e9, " s"},
+		durationStep{3.6e12, 6e10, " min"},
+		durationStep{8.64e13, 3.6e12, " hour"},
+		durationStep{math.Inf(1), 8.64e13, " day"},
+	)
+	toMicroseconds = durationSteps(
+		durationStep{1e3, 1, " µs"},
+		durationStep{1e6, 1e3, " ms"},
+		durationStep{math.Inf(1), 1e6, " s"},
+	)
+	toMilliseconds = durationSteps(
+		durationStep{1e3, 1, " ms"},
+		durationStep{6e4, 1e3, " s"},
+		durationStep{3.6e6, 6e4, " min"},
+		durationStep{8.64e7, 3.6e6, " hour"},
+		durationStep{3.1536e10, 8.64e7, " day"},
+		durationStep{math.Inf(1), 3.1536e10, " year"},
+	)
+	secondSteps = durationSteps(
+		durationStep{1e-6, 1e-9, " ns"},
+		durationStep{1e-3, 1e-6, " µs"},
+		durationStep{1, 1e-3, " ms"},
+		durationStep{60, 1, " s"},
+		durationStep{3600, 60, " min"},
+		durationStep{86400, 3600, " hour"},
+		durationStep{604800, 86400, " day"},
+		durationStep{31536000, 604800, " week"},
+		durationStep{math.Inf(1), 3.15569e7, " year"},
+	)
+	minuteSteps = durationSteps(
+		durationStep{60, 1, " min"},
+		durationStep{1440, 60, " hour"},
+		durationStep{10080, 1440, " day"},
+		durationStep{604800, 10080, " week"},
+		durationStep{math.Inf(1), 5.25948e5, " year"},
+	)
+	hourSteps = durationSteps(
+		durationStep{24, 1, " hour"},
+		durationStep{168, 24, " day"},
+		durationStep{8760, 168, " week"},
+		durationStep{math.Inf(1), 8760, " year"},
+	)
+	daySteps = durationSteps(
+		durationStep{7, 1, " day"},
+		durationStep{365, 7, " week"},
+		durationStep{math.Inf(1), 365, " year"},
+	)
+)
+
+// toSeconds shows 0 in seconds rather than in nanoseconds.
+func toSeconds(value float64, decimals *int) (string, string, string) {
+	if value == 0 {
+		return "", "0", " s"
+	}
+	return secondSteps(value, decimals)
+}
+
+func toMinutes(value float64, decimals *int) (string, string, string) {
+	if math.Abs(value) < 1 {
+		return toSeconds(value*60, decimals)
+	}
+	return minuteSteps(value, decimals)
+}
+
+func toHours(value float64, decimals *int) (string, string, string) {
+	if math.Abs(value) < 1 {
+		return toMinutes(value*60, decimals)
+	}
+	return hourSteps(value, decimals)
+}
+
+func toDays(value float64, decimals *int) (string, string, string) {
+	if math.Abs(value) < 1 {
+		return toHours(value*24, decimals)
+	}
+	return daySteps(value, decimals)
+}
+
+// boolUnit shows non-zero values as truth.
+func boolUnit(t, f string) unitFormatter {
+	return func(value float64, decimals *int) (string, string, string) {
+		if value != 0 {
+			return "", t, ""
+		}
+		return "", f, ""
+	}
+}
+
+// toFixed formats a number like Grafana's toFixed. Without decimals, whole numbers
+// have none and other numbers get enough to show two significant digits.
+func toFixed(value float64, decimals *int) string {
+	d := 0
+	if decimals != nil {
+		d = max(*decimals, 0)
+	} else {
+		d = decimalsForValue(value)
+	}
+	// round half up like javascript's Math.round
+	factor := math.Pow(10, float64(d))
+	if rounded := math.Floor(value*factor+0.5) / factor; !math.IsInf(rounded, 0) {
+		value = rounded
+	}
+	return strconv.FormatFloat(value, 'f', d, 64)
+}
+
+// decimalsForValue picks the decimals Grafana shows a number with.
+func decimalsForValue(value float64) int {
+	if value == math.Trunc(value) {
+		return 0
+	}
+	abs := math.Abs(value)
+	log10 := math.Floor(math.Log10(abs))
+	dec := -int(log10) + 1
+	magnitude := math.Pow(10, float64(-dec))
+	if abs/magnitude > 2.25 {
+		dec++
+	}
+	return max(dec, 0)
+}
@@ -0,0 +1,797 @@
+package grafanadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// errUnsupportedVariable is returned for variables whose type or query cannot be resolved.
+var errUnsupportedVariable = errors.New("unsupported variable")
+
+// Variable sort orders as stored in the dashboard json.
+const (
+	sortDisabled = iota
+	sortAlphabeticalAsc
+	sortAlphabeticalDesc
+	sortNumericalAsc
+	sortNumericalDesc
+	sortAlphabeticalCaseInsensitiveAsc
+	sortAlphabeticalCaseInsensitiveDesc
+	sortNaturalAsc
+	sortNaturalDesc
+)
+
+// resolveVariable returns the options of a template variable after applying its
+// regex filter, sort order and "All" option.
+func (c *Client) resolveVariable(ctx context.Context, tpl TemplateVariable, ip *Interpolator, tr timeRange) ([]string, error) {
+	var values []string
+
+	switch tpl.Type {
+	case "query":
+		query := variableQuery(tpl.Query)
+		if query == "" {
+			return nil, fmt.Errorf("%w: variable %v has no query", errUnsupportedVariable, tpl.Name)
+		}
+
+		// empty references select the default datasource, names and variables are looked up
+		ds, err := c.resolveDatasource(ctx, tpl.Datasource, ip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve datasource of variable %v: %w", tpl.Name, err)
+		}
+		if ds.Type != "" && ds.Type != "prometheus" {
+			return nil, fmt.Errorf("%w: query variables of datasource type %v", errUnsupportedVariable, ds.Type)
+		}
+
+		values, err = c.prometheusVariableValues(ctx, ds.UID, ip.Interpolate(query, "prometheus"), tr)
+		if err != nil {
+			return nil, err
+		}
+	case "custom":
+		for _, o := range customVariableOptions(variableQuery(tpl.Query)) {
+			values = append(values, o.Value)
+		}
+	case "interval":
+		for _, v := range strings.Split(variableQuery(tpl.Query), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	case "constant":
+		values = []string{variableQuery(tpl.Query)}
+	case "textbox":
+		value := variableQuery(tpl.Query)
+		if current, ok := tpl.Current.Value.(string); ok && current != "" {
+			value = current
+		}
+		values = []string{value}
+	case "datasource":
+		dsType := variableQuery(tpl.Query)
+		datasources, err := c.ListDatasourcesContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ds := range datasources {
+			if ds.Type == dsType {
+				values = append(values, ds.Name)
+			}
+		}
+	case "adhoc":
+		for _, f := range tpl.Filters {
+			values = append(values, fmt.Sprintf("%v%v%q", f.Key, f.Operator, f.Value))
+		}
+		// adhoc filters are never filtered, sorted or expanded
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: type %v", errUnsupportedVariable, tpl.Type)
+	}
+
+	values, err := applyVariableRegex(values, tpl.Regex)
+	if err != nil {
+		// javascript only syntax, such as lookbehinds, cannot be compiled by Go's regexp package
+		return nil, fmt.Errorf("%w: regex %v of variable %v: %v", errUnsupportedVariable, tpl.Regex, tpl.Name, err)
+	}
+	values = sortVariableValues(values, tpl.Sort)
+
+	if tpl.IncludeAll {
+		values = append([]string{allValue}, values...)
+	}
+
+	return values, nil
+}
+
+// variableQuery returns the query of a variable which is either a plain string or,
+// for newer dashboards, an object with a "query" key.
+func variableQuery(query any) string {
+	switch q := query.(type) {
+	case string:
+		return q
+	case map[string]any:
+		s, _ := q["query"].(string)
+		return s
+	}
+	return ""
+}
+
+var (
+	customValueRegex = regexp.MustCompile(`(?:\\,|[^,])+`)
+	customKeyValue   = regexp.MustCompile(`^\s*(.+)\s:\s(.+)$`)
+)
+
+// customVariableOptions parses the comma separated values of a custom variable.
+// Values may be written as "text : value" and commas escaped as "\,".
+func customVariableOptions(query string) []VariableOption {
+	var options []VariableOption
+	for _, match := range customValueRegex.FindAllString(query, -1) {
+		text := strings.TrimSpace(match)
+		value := text
+		if m := customKeyValue.FindStringSubmatch(text); m != nil {
+			text, value = m[1], m[2]
+		}
+		options = append(options, VariableOption{
+			Text:  strings.ReplaceAll(text, `\,`, ","),
+			Value: strings.ReplaceAll(value, `\,`, ","),
+		})
+	}
+	return options
+}
+
+// applyVariableRegex filters the values by a variable regex written as /pattern/flags.
+// If the regex has a capture group the first group (or the group named "value")
+// becomes the option value. Duplicates are removed.
+func applyVariableRegex(values []string, regex string) ([]string, error) {
+	if regex == "" {
+		return values, nil
+	}
+
+	pattern := regex
+	if strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			flags := pattern[end+1:]
+			pattern = pattern[1:end]
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+		}
+	}
+
+	re, err := compileOptionRegex(pattern)
+	if err != nil {
+		return nil, err
+	}
+	valueGroup := re.SubexpIndex("value")
+
+	seen := map[string]bool{}
+	var filtered []string
+	for _, v := range values {
+		m := re.FindStringSubmatch(v)
+		if m == nil {
+			continue
+		}
+		switch {
+		case valueGroup > 0 && m[valueGroup] != "":
+			v = m[valueGroup]
+		case len(m) > 1 && m[1] != "":
+			v = m[1]
+		}
+		if !seen[v] {
+			seen[v] = true
+			filtered = append(filtered, v)
+		}
+	}
+
+	return filtered, nil
+}
+
+// optionRegex is the regex filtering the options of a variable. Go's regexp package
+// has no lookaheads, so the lookaheads a pattern starts with, like in Grafana's
+// /^(?!__).*/ that hides __name__, are checked separately at the position the rest
+// of the pattern matches from.
+type optionRegex struct {
+	*regexp.Regexp
+	lookaheads []lookahead
+	anchored   bool // the pattern starts with ^, so it only matches from the start
+}
+
+// lookahead is a (?=...) or, when negative, a (?!...) assertion.
+type lookahead struct {
+	re       *regexp.Regexp
+	negative bool
+}
+
+// compileOptionRegex compiles the regex of a variable, which may start with lookaheads.
+func compileOptionRegex(pattern string) (*optionRegex, error) {
+	pattern = namedGroups(pattern)
+	flags := ""
+	if strings.HasPrefix(pattern, "(?i)") {
+		flags, pattern = "(?i)", strings.TrimPrefix(pattern, "(?i)")
+	}
+
+	rest := pattern
+	anchored := strings.HasPrefix(rest, "^")
+	rest = strings.TrimPrefix(rest, "^")
+
+	var lookaheads []lookahead
+	for strings.HasPrefix(rest, "(?=") || strings.HasPrefix(rest, "(?!") {
+		end := closingParen(rest)
+		if end < 0 {
+			break
+		}
+		re, err := regexp.Compile(flags + "^(?:" + rest[3:end] + ")")
+		if err != nil {
+			return nil, err
+		}
+		lookaheads = append(lookaheads, lookahead{re: re, negative: rest[2] == '!'})
+		rest = rest[end+1:]
+	}
+
+	if len(lookaheads) == 0 {
+		re, err := regexp.Compile(flags + pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &optionRegex{Regexp: re}, nil
+	}
+
+	re, err := regexp.Compile(flags + "^(?:" + rest + ")")
+	if err != nil {
+		return nil, err
+	}
+	return &optionRegex{Regexp: re, lookaheads: lookaheads, anchored: anchored}, nil
+}
+
+// namedGroups rewrites the (?<name>...) groups of JavaScript regexes, like the
+// (?<text>...) and (?<value>...) groups of variable regexes, to (?P<name>...), as
+// Go only accepts the JavaScript syntax since Go 1.22 and this module supports 1.21.
+func namedGroups(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			b.WriteString(pattern[i : i+2])
+			i++
+		case strings.HasPrefix(pattern[i:], "(?<") && !strings.HasPrefix(pattern[i:], "(?<=") && !strings.HasPrefix(pattern[i:], "(?<!"):
+			b.WriteString("(?P<")
+			i += 2
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// FindStringSubmatch returns the leftmost match of the regex and its groups, or nil.
+func (r *optionRegex) FindStringSubmatch(s string) []string {
+	if len(r.lookaheads) == 0 {
+		return r.Regexp.FindStringSubmatch(s)
+	}
+
+	for start := 0; start <= len(s); start++ {
+		if start > 0 && r.anchored {
+			break
+		}
+		if start < len(s) && !utf8.RuneStart(s[start]) {
+			continue
+		}
+		if !r.lookaheadsHold(s[start:]) {
+			continue
+		}
+		if m := r.Regexp.FindStringSubmatch(s[start:]); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (r *optionRegex) lookaheadsHold(s string) bool {
+	for _, l := range r.lookaheads {
+		if l.re.MatchString(s) == l.negative {
+			return false
+		}
+	}
+	return true
+}
+
+// closingParen returns the index of the parenthesis closing the group s starts with,
+// or -1. Escaped characters and character classes are skipped.
+func closingParen(s string) int {
+	depth := 0
+	inClass := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var firstNumber = regexp.MustCompile(`^.*?(\d+)`)
+
+// numericalSortKey returns the first number in s, or -1 like Grafana when there is none.
+func numericalSortKey(s string) int {
+	m := firstNumber.FindStringSubmatch(s)
+	if m == nil {
+		return -1
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// naturalLess compares strings treating runs of digits as numbers.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ad, bd := isDigit(a[0]), isDigit(b[0])
+		switch {
+		case ad && bd:
+			i, j := digitRun(a), digitRun(b)
+			na, _ := strconv.ParseUint(a[:i], 10, 64)
+			nb, _ := strconv.ParseUint(b[:j], 10, 64)
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[i:], b[j:]
+		case a[0] != b[0]:
+			return a[0] < b[0]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// sortVariableValues sorts the values with one of Grafana's variable sort orders.
+func sortVariableValues(values []string, order int) []string {
+	var less func(a, b string) bool
+	switch order {
+	case sortAlphabeticalAsc, sortAlphabeticalDesc:
+		less = func(a, b string) bool { return a < b }
+	case sortNumericalAsc, sortNumericalDesc:
+		less = func(a, b string) bool { return numericalSortKey(a) < numericalSortKey(b) }
+	case sortAlphabeticalCaseInsensitiveAsc, sortAlphabeticalCaseInsensitiveDesc:
+		less = func(a, b string) bool { return strings.ToLower(a) < strings.ToLower(b) }
+	case sortNaturalAsc, sortNaturalDesc:
+		less = naturalLess
+	default:
+		return values
+	}
+
+	desc := order == sortAlphabeticalDesc || order == sortNumericalDesc ||
+		order == sortAlphabeticalCaseInsensitiveDesc || order == sortNaturalDesc
+
+	sorted := append([]string(nil), values...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+
+	return sorted
+}
+
+var (
+	labelNamesRegex  = regexp.MustCompile(`^label_names\(\s*(.*?)\s*\)\s*$`)
+	labelValuesRegex = regexp.MustCompile(`^label_values\((?:(.+),\s*)?([a-zA-Z_$][a-zA-Z0-9_]*)\)\s*$`)
+	metricNamesRegex = regexp.MustCompile(`^metrics\((.+)\)\s*$`)
+	queryResultRegex = regexp.MustCompile(`^query_result\((.+)\)\s*$`)
+)
+
+// prometheusVariableValues resolves the Prometheus variable query functions
+// label_names(), label_values(), metrics() and query_result().
+func (c *Client) prometheusVariableValues(ctx context.Context, ds, query string, tr timeRange) ([]string, error) {
+	query = strings.TrimSpace(query)
+	params := timeRangeParams(tr)
+
+	if m := labelNamesRegex.FindStringSubmatch(query); m != nil {
+		if m[1] != "" {
+			params.Add("match[]", m[1])
+		}
+		var names []string
+		err := c.getPrometheusResource(ctx, ds, "api/v1/labels", params, &names)
+		return names, err
+	}
+
+	if m := labelValuesRegex.FindStringSubmatch(query); m != nil {
+		metric, label := strings.TrimSpace(m[1]), m[2]
+		if metric != "" {
+			params.Add("match[]", metric)
+		}
+		var values []string
+		err := c.getPrometheusResource(ctx, ds, "api/v1/label/"+label+"/values", params, &values)
+		return values, err
+	}
+
+	if m := metricNamesRegex.FindStringSubmatch(query); m != nil {
+		var names []string
+		if err := c.getPrometheusResource(ctx, ds, "api/v1/label/__name__/values", params, &names); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(strings.TrimSpace(m[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics regex: %w", err)
+		}
+		var values []string
+		for _, name := range names {
+			if re.MatchString(name) {
+				values = append(values, name)
+			}
+		}
+		return values, nil
+	}
+
+	if m := queryResultRegex.FindStringSubmatch(query); m != nil {
+		return c.queryResultValues(ctx, ds, m[1], tr)
+	}
+
+	return nil, fmt.Errorf("%w: prometheus query %v", errUnsupportedVariable, query)
+}
+
+// queryResultValues runs an instant query and formats every sample as
+// `metric{label="value"} value timestamp`, like Grafana's query_result().
+func (c *Client) queryResultValues(ctx context.Context, ds, query string, tr timeRange) ([]string, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	if !tr.End.IsZero() {
+		params.Set("time", strconv.FormatInt(tr.End.Unix(), 10))
+	}
+
+	var data struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []any             `json:"value"`
+		} `json:"result"`
+	}
+	if err := c.getPrometheusResource(ctx, ds, "api/v1/query", params, &data); err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, r := range data.Result {
+		name := r.Metric["__name__"]
+		var labels []string
+		for k, v := range r.Metric {
+			if k != "__name__" {
+				labels = append(labels, fmt.Sprintf("%v=%q", k, v))
+			}
+		}
+		sort.Strings(labels)
+
+		text := name + "{" + strings.Join(labels, ",") + "}"
+		if len(r.Value) == 2 {
+			ts, _ := r.Value[0].(float64)
+			text += fmt.Sprintf(" %v %v", r.Value[1], int64(ts*1000))
+		}
+		values = append(values, text)
+	}
+
+	return values, nil
+}
+
+func timeRangeParams(tr timeRange) url.Values {
+	params := url.Values{}
+	if !tr.Start.IsZero() {
+		params.Set("start", strconv.FormatInt(tr.Start.Unix(), 10))
+	}
+	if !tr.End.IsZero() {
+		params.Set("end", strconv.FormatInt(tr.End.Unix(), 10))
+	}
+	return params
+}
+
+// getPrometheusResource calls the Prometheus HTTP API through Grafana's datasource
+// resource proxy and decodes the "data" field of the response into out.
+func (c *Client) getPrometheusResource(ctx context.Context, ds, path string, params url.Values, out any) error {
+	host := strings.TrimSuffix(c.baseURL.String(), "/")
+	endpoint := fmt.Sprintf("%s/api/datasources/uid/%s/resources/%s", host, ds, path)
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	c.log.Debug("getting prometheus resource", "endpoint", endpoint)
+
+	req, err := c.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(req, resp.StatusCode, body)
+	}
+
+	var promResponse struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}
+	if err := json.Unmarshal(body, &promResponse); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if promResponse.Status != "success" {
+		return fmt.Errorf("grafana API returned status: %s %s", promResponse.Status, promResponse.Error)
+	}
+
+	if err := json.Unmarshal(promResponse.Data, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response data: %w", err)
+	}
+
+	return nil
+}
+
+// ResolvedVariable is a template variable with its resolved options and selection.
+type ResolvedVariable struct {
+	Name       string
+	Type       string
+	Options    []string // starts with "$__all" when the variable includes the "All" option
+	Selected   []string
+	Multi      bool
+	IncludeAll bool
+	AllValue   string
+	DependsOn  []string
+}
+
+// value returns the interpolation value for the selection.
+func (v ResolvedVariable) value() VariableValue {
+	value := VariableValue{
+		Values:     v.Selected,
+		Multi:      v.Multi,
+		IncludeAll: v.IncludeAll,
+		AllValue:   v.AllValue,
+	}
+	for _, o := range v.Options {
+		if o != allValue {
+			value.Options = append(value.Options, o)
+		}
+	}
+	return value
+}
+
+// VariableSet holds the resolved template variables of a dashboard in resolution order.
+// Pass it to panel queries with WithVariableSet.
+type VariableSet struct {
+	Order     []string
+	Variables map[string]ResolvedVariable
+}
+
+// Options returns the resolved options of every variable. Variables that could not
+// be resolved are omitted.
+func (s VariableSet) Options() map[string][]string {
+	options := make(map[string][]string, len(s.Variables))
+	for name, v := range s.Variables {
+		if v.Options != nil {
+			options[name] = v.Options
+		}
+	}
+	return options
+}
+
+// Selected returns the selected values of every variable.
+func (s VariableSet) Selected() map[string][]string {
+	selected := make(map[string][]string, len(s.Variables))
+	for name, v := range s.Variables {
+		selected[name] = v.Selected
+	}
+	return selected
+}
+
+// WithVariableSet uses the selected values of a resolved VariableSet for the panel query.
+// Variables set with WithVariables or WithMultiValueVariables after it take precedence.
+func WithVariableSet(set VariableSet) func(*panelOptions) {
+	return WithMultiValueVariables(set.Selected())
+}
+
+// variableDependencies returns the names of the dashboard variables referenced by tpl.
+func variableDependencies(tpl TemplateVariable, names map[string]bool) []string {
+	texts := []string{variableQuery(tpl.Query), tpl.Regex, tpl.Datasource.UID, tpl.AllValue}
+
+	seen := map[string]bool{}
+	var deps []string
+	for _, text := range texts {
+		for _, groups := range variableRegex.FindAllStringSubmatch(text, -1) {
+			name := groups[1] + groups[2] + groups[4]
+			if !names[name] || seen[name] {
+				continue
+			}
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	return deps
+}
+
+// variableOrder sorts the variables topologically by their references, keeping the
+// dashboard order between independent variables. It fails with ErrVariableCycle.
+func variableOrder(list []TemplateVariable) ([]int, map[string][]string, error) {
+	index := make(map[string]int, len(list))
+	names := make(map[string]bool, len(list))
+	for i, tpl := range list {
+		index[tpl.Name] = i
+		names[tpl.Name] = true
+	}
+
+	deps := make(map[string][]string, len(list))
+	for _, tpl := range list {
+		deps[tpl.Name] = variableDependencies(tpl, names)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(list))
+	var order []int
+	var path []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for j, name := range path {
+				if name == list[i].Name {
+					start = j
+				}
+			}
+			cycle := append(append([]string(nil), path[start:]...), list[i].Name)
+			return fmt.Errorf("%w: %v", ErrVariableCycle, strings.Join(cycle, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, list[i].Name)
+		for _, dep := range deps[list[i].Name] {
+			if err := visit(index[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		order = append(order, i)
+		return nil
+	}
+
+	for i := range list {
+		if err := visit(i); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return order, deps, nil
+}
+
+// selectVariableValues picks the selection for a variable: explicit values win, then
+// the saved current value if it is still a valid option, then the first option.
+func selectVariableValues(tpl TemplateVariable, options []string, explicit []string, hasExplicit bool) []string {
+	if hasExplicit {
+		return explicit
+	}
+
+	current := tpl.Current.Values()
+	if len(options) == 0 || tpl.Type == "textbox" || tpl.Type == "constant" || tpl.Type == "adhoc" {
+		if len(current) > 0 || len(options) == 0 {
+			return current
+		}
+		return options
+	}
+
+	valid := map[string]bool{}
+	for _, o := range options {
+		valid[o] = true
+	}
+
+	var selected []string
+	for _, v := range current {
+		if valid[v] {
+			selected = append(selected, v)
+		}
+	}
+	if len(selected) > 0 {
+		return selected
+	}
+
+	return options[:1]
+}
+
+// resolveVariableSet resolves the variables in dependency order so that every query is
+// interpolated with the selection of the variables it references.
+func (c *Client) resolveVariableSet(ctx context.Context, list []TemplateVariable, options panelOptions) (VariableSet, error) {
+	set := VariableSet{Variables: make(map[string]ResolvedVariable, len(list))}
+
+	order, deps, err := variableOrder(list)
+	if err != nil {
+		return set, err
+	}
+
+	ip := NewInterpolator(nil)
+	for _, i := range order {
+		tpl := list[i]
+
+		values, err := c.resolveVariable(ctx, tpl, ip, options.timerange)
+		if errors.Is(err, errUnsupportedVariable) {
+			// keep the saved selection so that queries can still be interpolated
+			c.log.Warn("unhandled variable", "tpl", tpl, "error", err)
+			values = nil
+		} else if err != nil {
+			return set, fmt.Errorf("failed to resolve variable %s: %w", tpl.Name, err)
+		}
+
+		explicit, hasExplicit := options.variables[tpl.Name]
+		resolved := ResolvedVariable{
+			Name:       tpl.Name,
+			Type:       tpl.Type,
+			Options:    values,
+			Selected:   selectVariableValues(tpl, values, explicit, hasExplicit),
+			Multi:      tpl.Multi,
+			IncludeAll: tpl.IncludeAll,
+			AllValue:   tpl.AllValue,
+			DependsOn:  deps[tpl.Name],
+		}
+
+		set.Order = append(set.Order, tpl.Name)
+		set.Variables[tpl.Name] = resolved
+		ip.Variables[tpl.Name] = resolved.value()
+	}
+
+	return set, nil
+}
+
+// ResolveVariables resolves the template variables of a dashboard in dependency order.
+func (c *Client) ResolveVariables(response DashboardResponse, opts ...PanelOption) (VariableSet, error) {
+	return c.ResolveVariablesContext(context.Background(), response, opts...)
+}
+
+// ResolveVariablesContext resolves the template variables of a dashboard in dependency order using the provided context.
+func (c *Client) ResolveVariablesContext(ctx context.Context, response DashboardResponse, opts ...PanelOption) (VariableSet, error) {
+	options := newPanelOptions(opts...)
+	return c.resolveVariableSet(ctx, response.Dashboard.Templating.List, options)
+}